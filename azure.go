@@ -0,0 +1,284 @@
+package fifo
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// azureDefaultBlockSize is the size of each staged block unless set by `?block_size`.
+const azureDefaultBlockSize = 4 << 20
+
+// azureDevelopmentAccount is the well-known account of the Azurite storage emulator.
+const (
+	azureDevelopmentAccount  = "devstoreaccount1"
+	azureDevelopmentKey      = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+	azureDevelopmentEndpoint = "http://127.0.0.1:10000/devstoreaccount1"
+)
+
+// AzureBlockBlob uploads a block blob by staging blocks as they are written.
+// The staged blocks are committed when the blob is committed.
+type AzureBlockBlob struct {
+	blob    azblob.BlockBlobURL
+	headers azblob.BlobHTTPHeaders
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	prefix    string
+	blocks    []string
+	buf       []byte
+	committed bool
+}
+
+func (b *AzureBlockBlob) stage() error {
+	if len(b.blocks) == azblob.BlockBlobMaxBlocks {
+		return errors.Errorf("blob exceeds the maximum of %d blocks of %d bytes, use a larger ?block_size", azblob.BlockBlobMaxBlocks, cap(b.buf))
+	}
+
+	id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s-%08d", b.prefix, len(b.blocks))))
+	_, err := b.blob.StageBlock(b.ctx, id, bytes.NewReader(b.buf), azblob.LeaseAccessConditions{}, nil)
+	if err != nil {
+		return err
+	}
+
+	b.blocks = append(b.blocks, id)
+	b.buf = b.buf[:0]
+	return nil
+}
+
+func (b *AzureBlockBlob) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		c := copy(b.buf[len(b.buf):cap(b.buf)], p)
+		b.buf = b.buf[:len(b.buf)+c]
+		p = p[c:]
+		n += c
+
+		if len(b.buf) == cap(b.buf) {
+			err = b.stage()
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Close stages any remaining data.
+func (b *AzureBlockBlob) Close() error {
+	if len(b.buf) == 0 {
+		return nil
+	}
+	return b.stage()
+}
+
+// Commit commits the staged blocks.
+func (b *AzureBlockBlob) Commit() error {
+	_, err := b.blob.CommitBlockList(b.ctx, b.blocks, b.headers, azblob.Metadata{}, azblob.BlobAccessConditions{})
+	if err != nil {
+		return err
+	}
+
+	b.committed = true
+	return nil
+}
+
+// Checksum records the MD5 and SHA-256 digests of the blob as blob metadata.
+func (b *AzureBlockBlob) Checksum(d Digests) error {
+	_, err := b.blob.SetMetadata(context.Background(), azblob.Metadata{
+		"md5":    hex.EncodeToString(d["md5"]),
+		"sha256": hex.EncodeToString(d["sha256"]),
+	}, azblob.BlobAccessConditions{})
+	return err
+}
+
+// Destroy deletes the blob if it has been committed.
+// Otherwise, if the blob does not already exist, the staged blocks are discarded.
+func (b *AzureBlockBlob) Destroy() error {
+	b.cancel()
+
+	ctx := context.Background()
+	if !b.committed {
+		// Committing an empty block list discards all uncommitted blocks,
+		// but must not replace a blob which already existed before the upload.
+		_, err := b.blob.CommitBlockList(ctx, []string{}, azblob.BlobHTTPHeaders{}, azblob.Metadata{}, azblob.BlobAccessConditions{
+			ModifiedAccessConditions: azblob.ModifiedAccessConditions{IfNoneMatch: azblob.ETagAny},
+		})
+		if isAzureStatus(err, http.StatusConflict, http.StatusPreconditionFailed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := b.blob.Delete(ctx, azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{})
+	if isAzureStatus(err, http.StatusNotFound) {
+		return nil
+	}
+	return err
+}
+
+func isAzureStatus(err error, codes ...int) bool {
+	se, ok := err.(azblob.StorageError)
+	if !ok || se.Response() == nil {
+		return false
+	}
+	for _, c := range codes {
+		if se.Response().StatusCode == c {
+			return true
+		}
+	}
+	return false
+}
+
+// AzureBlobProvider provides blobs from Azure Blob Storage.
+// The URL host is the container and the path is the blob name.
+type AzureBlobProvider struct {
+	// Endpoint is the URL of the blob service. Defaults to https://<account>.blob.core.windows.net
+	Endpoint string
+	Account  string
+	Key      string
+	// Retry is used to resume reading a blob after a transient error unless overridden by the URL.
+	Retry RetryPolicy
+}
+
+// ParseAzureConnectionString creates an AzureBlobProvider from an Azure storage connection string.
+func ParseAzureConnectionString(s string) (*AzureBlobProvider, error) {
+	var (
+		p        = new(AzureBlobProvider)
+		protocol = "https"
+		suffix   = "core.windows.net"
+	)
+
+	for _, part := range strings.Split(s, ";") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		switch strings.ToLower(kv[0]) {
+		case "usedevelopmentstorage":
+			if strings.EqualFold(kv[1], "true") {
+				p.Account = azureDevelopmentAccount
+				p.Key = azureDevelopmentKey
+				p.Endpoint = azureDevelopmentEndpoint
+			}
+		case "defaultendpointsprotocol":
+			protocol = kv[1]
+		case "accountname":
+			p.Account = kv[1]
+		case "accountkey":
+			p.Key = kv[1]
+		case "blobendpoint":
+			p.Endpoint = kv[1]
+		case "endpointsuffix":
+			suffix = kv[1]
+		}
+	}
+
+	if p.Account == "" {
+		return nil, errors.New("no AccountName found in Azure storage connection string")
+	}
+	if p.Endpoint == "" {
+		p.Endpoint = fmt.Sprintf("%s://%s.blob.%s", protocol, p.Account, suffix)
+	}
+
+	return p, nil
+}
+
+func (AzureBlobProvider) Schema() []string {
+	return []string{"az", "azblob"}
+}
+
+// Blob returns the block blob of a given URL.
+func (p *AzureBlobProvider) Blob(u *url.URL) (azblob.BlockBlobURL, error) {
+	if p.Account == "" || p.Key == "" {
+		return azblob.BlockBlobURL{}, errors.New("no Azure storage account credentials are configured")
+	}
+
+	credential, err := azblob.NewSharedKeyCredential(p.Account, p.Key)
+	if err != nil {
+		return azblob.BlockBlobURL{}, err
+	}
+
+	endpoint := p.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", p.Account)
+	}
+
+	service, err := url.Parse(endpoint)
+	if err != nil {
+		return azblob.BlockBlobURL{}, errors.Wrap(err, "invalid Azure blob endpoint")
+	}
+
+	return azblob.NewServiceURL(*service, azblob.NewPipeline(credential, azblob.PipelineOptions{})).
+		NewContainerURL(u.Host).
+		NewBlockBlobURL(strings.TrimPrefix(u.Path, "/")), nil
+}
+
+func (p *AzureBlobProvider) Read(u *url.URL) (io.ReadCloser, error) {
+	policy, err := p.Retry.Override(u.Query())
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := p.Blob(u)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewResumableReader(policy, func(ctx context.Context, offset int64) (io.ReadCloser, string, error) {
+		resp, err := blob.Download(ctx, offset, azblob.CountToEnd, azblob.BlobAccessConditions{}, false)
+		if err != nil {
+			return nil, "", err
+		}
+
+		return resp.Body(azblob.RetryReaderOptions{}), string(resp.ETag()), nil
+	})
+}
+
+func (p *AzureBlobProvider) Write(u *url.URL) (WriteDestroyCloser, error) {
+	q := u.Query()
+
+	blockSize := azureDefaultBlockSize
+	if v := q.Get("block_size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > azblob.BlockBlobMaxStageBlockBytes {
+			return nil, errors.Errorf("invalid block_size option %q", v)
+		}
+		blockSize = n
+	}
+
+	blob, err := p.Blob(u)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, 8)
+	_, err = rand.Read(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &AzureBlockBlob{
+		blob: blob,
+		headers: azblob.BlobHTTPHeaders{
+			ContentType: q.Get("type"),
+		},
+		ctx:    ctx,
+		cancel: cancel,
+		prefix: hex.EncodeToString(prefix),
+		buf:    make([]byte, 0, blockSize),
+	}, nil
+}
@@ -0,0 +1,214 @@
+package fifo
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"hash"
+	"io"
+	"net/url"
+	"sort"
+)
+
+// hashes creates a hash for each supported digest algorithm.
+var hashes = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// Digests maps the name of a hash algorithm to the digest of a stream.
+type Digests map[string][]byte
+
+// ParseDigests reads the expected digests of a stream from the `?md5`, `?sha1`, `?sha256` and `?sha512` options of a URL.
+func ParseDigests(q url.Values) (Digests, error) {
+	d := make(Digests)
+	for name, h := range hashes {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+
+		b, err := hex.DecodeString(v)
+		if err != nil || len(b) != h().Size() {
+			return nil, errors.Errorf("invalid %s digest %q", name, v)
+		}
+		d[name] = b
+	}
+	return d, nil
+}
+
+// ChecksumError is returned when the digest of a stream does not match the expected digest.
+type ChecksumError struct {
+	Algorithm string
+	Expected  []byte
+	Actual    []byte
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("%s checksum mismatch: expected %x but got %x", e.Algorithm, e.Expected, e.Actual)
+}
+
+// Verify compares the digests against the expected digests,
+// returning a *ChecksumError for the first mismatch.
+func (d Digests) Verify(expected Digests) error {
+	names := make([]string, 0, len(expected))
+	for name := range expected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !bytes.Equal(d[name], expected[name]) {
+			return &ChecksumError{
+				Algorithm: name,
+				Expected:  expected[name],
+				Actual:    d[name],
+			}
+		}
+	}
+	return nil
+}
+
+// digester computes the digests of a stream using multiple algorithms.
+type digester map[string]hash.Hash
+
+func newDigester(algorithms ...string) digester {
+	d := make(digester)
+	for _, name := range algorithms {
+		d[name] = hashes[name]()
+	}
+	return d
+}
+
+func (d digester) Write(b []byte) (int, error) {
+	for _, h := range d {
+		_, _ = h.Write(b)
+	}
+	return len(b), nil
+}
+
+func (d digester) Digests() Digests {
+	digests := make(Digests)
+	for name, h := range d {
+		digests[name] = h.Sum(nil)
+	}
+	return digests
+}
+
+// A Checksummer is a target stream which can verify the digests of its content against the remote object,
+// or record them as metadata of the object, once it has been closed.
+type Checksummer interface {
+	Checksum(Digests) error
+}
+
+// ChecksumReader verifies the digests of a source stream once the stream has been read to the end.
+type ChecksumReader struct {
+	io.ReadCloser
+	d        digester
+	expected Digests
+}
+
+// Read returns a *ChecksumError instead of io.EOF if the digests of the stream do not match.
+func (r *ChecksumReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	_, _ = r.d.Write(b[:n])
+	if err == io.EOF {
+		if verr := r.d.Digests().Verify(r.expected); verr != nil {
+			return n, verr
+		}
+	}
+	return n, err
+}
+
+// ChecksumWriter computes the digests of a target stream while it is being written.
+type ChecksumWriter struct {
+	Target   WriteDestroyCloser
+	d        digester
+	expected Digests
+	verify   bool
+}
+
+func (w *ChecksumWriter) Write(b []byte) (int, error) {
+	n, err := w.Target.Write(b)
+	_, _ = w.d.Write(b[:n])
+	return n, err
+}
+
+// Close compares the digests of the stream against the expected digests and closes the target.
+func (w *ChecksumWriter) Close() error {
+	mu := new(MultiError)
+	mu.Append(w.d.Digests().Verify(w.expected))
+	mu.Catch(w.Target.Close)
+	if _, ok := w.Target.(Checksummer); w.verify && !ok {
+		mu.Append(errors.New("the target provider does not support checksum verification"))
+	}
+	return mu.AsError()
+}
+
+// Commit commits the target.
+// If verification is enabled the digests are then given to the target to verify against the committed object.
+func (w *ChecksumWriter) Commit() error {
+	err := w.Target.Commit()
+	if err != nil || !w.verify {
+		return err
+	}
+
+	c, ok := w.Target.(Checksummer)
+	if !ok {
+		return errors.New("the target provider does not support checksum verification")
+	}
+
+	return errors.Wrap(c.Checksum(w.d.Digests()), "unable to verify target checksum")
+}
+
+func (w *ChecksumWriter) Destroy() error {
+	return w.Target.Destroy()
+}
+
+// VerifySource wraps a source stream with a reader verifying the expected digests of the stream.
+// The stream is returned as-is if there are no expected digests.
+func VerifySource(r io.ReadCloser, expected Digests) io.ReadCloser {
+	if len(expected) == 0 {
+		return r
+	}
+
+	var algorithms []string
+	for name := range expected {
+		algorithms = append(algorithms, name)
+	}
+
+	return &ChecksumReader{
+		ReadCloser: r,
+		d:          newDigester(algorithms...),
+		expected:   expected,
+	}
+}
+
+// VerifyTarget wraps a target stream with a writer computing the digests of the stream.
+// When closed, the digests are compared against the expected digests
+// and, if verify is enabled, given to the target to verify against the remote object once committed.
+// The stream is returned as-is if there are no expected digests and verification is not enabled.
+func VerifyTarget(w WriteDestroyCloser, expected Digests, verify bool) WriteDestroyCloser {
+	if len(expected) == 0 && !verify {
+		return w
+	}
+
+	algorithms := []string{"md5", "sha256"}
+	for name := range expected {
+		algorithms = append(algorithms, name)
+	}
+
+	return &ChecksumWriter{
+		Target:   w,
+		d:        newDigester(algorithms...),
+		expected: expected,
+		verify:   verify,
+	}
+}
@@ -4,34 +4,136 @@ import (
 	"context"
 	"fmt"
 	"github.com/jessevdk/go-flags"
+	"github.com/pkg/errors"
 	"github.com/relvacode/fifo"
 	"github.com/relvacode/fifo/build"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
+	"time"
 )
 
 type TaskOptions struct {
 	Sources fifo.UrlMapping `short:"s" long:"source" description:"Describe input sources"`
 	Targets fifo.UrlMapping `short:"t" long:"target" description:"Describe targets"`
 
-	Preserve bool `long:"preserve" description:"Preserve created targets on command failure"`
+	SpoolSources fifo.UrlMapping `short:"S" long:"spool-source" description:"Describe input sources downloaded to a temporary file before the command starts"`
+	SpoolTargets fifo.UrlMapping `short:"T" long:"spool-target" description:"Describe targets written to a temporary file and uploaded once the command succeeds"`
+
+	Mode         string         `long:"mode" choice:"fifo" choice:"fd" default:"fifo" description:"Pass sources and targets to the command as named pipes (fifo) or as inherited file descriptors (fd)"`
+	MountDir     string         `long:"mount-dir" value-name:"DIR" description:"Create named pipes in a temporary directory within this directory (default: the system temporary directory)"`
+	PipeMode     os.FileMode    `long:"pipe-mode" base:"8" default:"0600" value-name:"MODE" description:"Octal permissions of named pipes and spooled sources"`
+	PipeNames    string         `long:"pipe-names" choice:"random" choice:"tag" choice:"basename" default:"random" description:"Name pipes after their URL with a random prefix (random), after their tag with the extension of their URL (tag), or after the file name of their URL (basename)"`
+	OpenTimeout  time.Duration  `long:"open-timeout" description:"Fail if the command does not open a pipe within this duration (default: until the command exits)"`
+	Preserve     bool           `long:"preserve" description:"Preserve created targets on command failure"`
+	SuccessCodes fifo.ExitCodes `long:"success-codes" value-name:"CODES" description:"Comma separated exit codes of the command considered successful (default: 0)"`
+
+	Retries      int           `long:"retries" default:"3" description:"Resume reading HTTP and object storage sources this many times after a transient error"`
+	RetryBackoff time.Duration `long:"retry-backoff" default:"1s" description:"Delay before resuming a source, doubled after each attempt"`
 
 	Stdin  *fifo.Url `long:"stdin" description:"Read command STDIN from this target (default: STDIN)"`
 	Stdout *fifo.Url `long:"stdout" description:"Write command STDOUT to this target (default: STDOUT)"`
 	Stderr *fifo.Url `long:"stderr" description:"Write command STDERR to this target (default: STDERR)"`
 }
 
+type HTTPOptions struct {
+	Header     map[string]string `long:"http-header" value-name:"NAME:VALUE" description:"Send this header with every HTTP request"`
+	CABundle   string            `long:"http-ca-bundle" value-name:"FILE" description:"Trust the certificate authorities in this PEM file for HTTPS"`
+	ClientCert string            `long:"http-cert" value-name:"FILE" description:"Authenticate to HTTPS servers with this client certificate"`
+	ClientKey  string            `long:"http-key" value-name:"FILE" description:"Private key of the HTTPS client certificate"`
+}
+
+// Provider creates the HTTP provider from the given options.
+// Credentials are read from FIFO_HTTP_TOKEN, FIFO_HTTP_USERNAME and FIFO_HTTP_PASSWORD, sent only to FIFO_HTTP_HOST,
+// or otherwise the netrc file given by NETRC (default: ~/.netrc, if the home directory is known).
+func (o HTTPOptions) Provider() (*fifo.HTTPProvider, error) {
+	client, err := fifo.NewHTTPClient(o.CABundle, o.ClientCert, o.ClientKey)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make(http.Header)
+	for k, v := range o.Header {
+		header.Set(strings.TrimSpace(k), strings.TrimSpace(v))
+	}
+
+	netrc := os.Getenv("NETRC")
+	if home, err := os.UserHomeDir(); netrc == "" && err == nil {
+		netrc = filepath.Join(home, ".netrc")
+	}
+
+	return &fifo.HTTPProvider{
+		Client:   client,
+		Header:   header,
+		Token:    os.Getenv("FIFO_HTTP_TOKEN"),
+		Username: os.Getenv("FIFO_HTTP_USERNAME"),
+		Password: os.Getenv("FIFO_HTTP_PASSWORD"),
+		AuthHost: os.Getenv("FIFO_HTTP_HOST"),
+		Netrc:    netrc,
+	}, nil
+}
+
+type S3Options struct {
+	Profile    string `long:"s3-profile" value-name:"PROFILE" description:"Use this AWS shared configuration profile unless overridden by ?profile"`
+	RoleARN    string `long:"s3-role-arn" value-name:"ARN" description:"Assume this IAM role unless overridden by ?role_arn"`
+	ExternalID string `long:"s3-external-id" value-name:"ID" description:"Pass this external ID when assuming a role unless overridden by ?external_id"`
+	PathStyle  bool   `long:"s3-path-style" description:"Address buckets in the request path rather than the hostname, such as for MinIO, unless overridden by ?path_style"`
+
+	PartSize    int64 `long:"s3-part-size" value-name:"BYTES" default:"5242880" description:"Upload objects in parts of this many bytes unless overridden by ?part_size"`
+	Concurrency int   `long:"s3-concurrency" value-name:"N" default:"5" description:"Upload this many parts at the same time unless overridden by ?concurrency"`
+}
+
+// Provider creates the S3 provider from the given options.
+// The endpoint and region are read from AWS_ENDPOINT and AWS_REGION.
+func (o S3Options) Provider(retry fifo.RetryPolicy) *fifo.S3Provider {
+	return &fifo.S3Provider{
+		Endpoint:    os.Getenv("AWS_ENDPOINT"),
+		Region:      os.Getenv("AWS_REGION"),
+		Profile:     o.Profile,
+		RoleARN:     o.RoleARN,
+		ExternalID:  o.ExternalID,
+		PathStyle:   o.PathStyle,
+		PartSize:    o.PartSize,
+		Concurrency: o.Concurrency,
+		Retry:       retry,
+	}
+}
+
+type EncryptionOptions struct {
+	Recipients     []string `long:"recipient" value-name:"PUBLIC_KEY" description:"Encrypt targets with ?encrypt to this age recipient"`
+	RecipientFiles []string `long:"recipients-file" value-name:"FILE" description:"Encrypt targets with ?encrypt to the age recipients in this file"`
+	Identities     []string `long:"identity" value-name:"FILE" description:"Decrypt sources with ?decrypt using the age identities in this file"`
+	PassphraseFile string   `long:"passphrase-file" value-name:"FILE" description:"Encrypt and decrypt using the passphrase in this file"`
+}
+
+// Keys parses the default keys used to encrypt and decrypt streams.
+func (o EncryptionOptions) Keys() (keys fifo.Keys, err error) {
+	keys.Recipients, err = fifo.ParseRecipients(o.Recipients, o.RecipientFiles, o.PassphraseFile)
+	if err != nil {
+		return
+	}
+
+	keys.Identities, err = fifo.ParseIdentities(o.Identities, o.PassphraseFile)
+	return
+}
+
+// CommandOptions is the command to run, which is required unless cleaning up S3 uploads.
 type CommandOptions struct {
-	Executable string `required:"true"`
+	Executable string
 	Args       []string
 }
 
 type Options struct {
-	TaskOptions `group:"Task Options"`
-	Command     CommandOptions `positional-args:"yes" required:"yes"`
+	TaskOptions       `group:"Task Options"`
+	HTTPOptions       `group:"HTTP Options"`
+	S3Options         `group:"S3 Options"`
+	S3CleanupOptions  `group:"S3 Cleanup Options"`
+	EncryptionOptions `group:"Encryption Options"`
+	Command           CommandOptions `positional-args:"yes"`
 }
 
 func signalContext(ctx context.Context, signals ...os.Signal) context.Context {
@@ -61,16 +163,76 @@ func Main() (code int, mu *fifo.MultiError) {
 		return
 	}
 
-	// Setup directory to mount pipes
-	temporaryLocation, err := ioutil.TempDir("", "fifo")
+	if o.S3CleanupOptions.Prefix != nil {
+		if o.Command.Executable != "" {
+			mu = fifo.Catch(mu, errors.New("a command cannot be run when cleaning up S3 uploads"))
+			return
+		}
+		mu = fifo.Catch(mu, o.S3CleanupOptions.Cleanup(o.S3Options.Provider(fifo.RetryPolicy{})))
+		return
+	}
+
+	if o.Command.Executable == "" {
+		mu = fifo.Catch(mu, errors.New("the required argument `Executable` was not provided"))
+		return
+	}
+
+	httpProvider, err := o.HTTPOptions.Provider()
 	if err != nil {
 		mu = fifo.Catch(mu, err)
 		return
 	}
 
-	defer func() {
-		mu.Append(os.RemoveAll(temporaryLocation))
-	}()
+	keys, err := o.EncryptionOptions.Keys()
+	if err != nil {
+		mu = fifo.Catch(mu, err)
+		return
+	}
+
+	retry := fifo.RetryPolicy{
+		Retries: o.Retries,
+		Backoff: o.RetryBackoff,
+	}
+
+	httpProvider.Retry = retry
+
+	azureProvider := &fifo.AzureBlobProvider{
+		Account: os.Getenv("AZURE_STORAGE_ACCOUNT"),
+		Key:     os.Getenv("AZURE_STORAGE_KEY"),
+	}
+	if cs := os.Getenv("AZURE_STORAGE_CONNECTION_STRING"); cs != "" {
+		azureProvider, err = fifo.ParseAzureConnectionString(cs)
+		if err != nil {
+			mu = fifo.Catch(mu, err)
+			return
+		}
+	}
+	if endpoint := os.Getenv("AZURE_STORAGE_ENDPOINT"); endpoint != "" {
+		azureProvider.Endpoint = endpoint
+	}
+
+	azureProvider.Retry = retry
+
+	// Spooled sources and targets are those with the spool option enabled
+	sources := o.Sources
+	if sources == nil {
+		sources = make(fifo.UrlMapping)
+	}
+	err = sources.Merge(o.SpoolSources, "spool")
+	if err != nil {
+		mu = fifo.Catch(mu, err)
+		return
+	}
+
+	targets := o.Targets
+	if targets == nil {
+		targets = make(fifo.UrlMapping)
+	}
+	err = targets.Merge(o.SpoolTargets, "spool")
+	if err != nil {
+		mu = fifo.Catch(mu, err)
+		return
+	}
 
 	t := &fifo.Task{
 		Call: fifo.Call{
@@ -78,27 +240,54 @@ func Main() (code int, mu *fifo.MultiError) {
 			Args:        o.Command.Args,
 			Environment: os.Environ(),
 		},
-		Preserve:       o.Preserve,
-		MountDirectory: temporaryLocation,
+		Preserve:     o.Preserve,
+		SuccessCodes: o.SuccessCodes,
+		PipeMode:     o.PipeMode,
+		PipeNames:    fifo.PipeNamers[o.PipeNames],
+		Mode:         o.Mode,
+		OpenTimeout:  o.OpenTimeout,
+		Keys:         keys,
 		Providers: []fifo.Provider{
 			fifo.FileProvider{},
-			&fifo.HTTPProvider{
-				Client: http.DefaultClient,
-			},
-			&fifo.S3Provider{
-				Endpoint: os.Getenv("AWS_ENDPOINT"),
-				Region:   os.Getenv("AWS_REGION"),
+			httpProvider,
+			o.S3Options.Provider(retry),
+			fifo.GCSProvider{
+				Retry: retry,
 			},
+			azureProvider,
+			fifo.SFTPProvider{},
 		},
 
-		Sources: o.Sources,
-		Targets: o.Targets,
+		Sources: sources,
+		Targets: targets,
 
 		Stdin:  o.Stdin,
 		Stdout: o.Stdout,
 		Stderr: o.Stderr,
 	}
 
+	// Setup directory to mount pipes, which is only accessible by the current user.
+	// Not needed if all sources and targets are passed as file descriptors.
+	if t.UsesMountDirectory() {
+		if o.MountDir != "" {
+			err = os.MkdirAll(o.MountDir, 0700)
+			if err != nil {
+				mu = fifo.Catch(mu, err)
+				return
+			}
+		}
+
+		t.MountDirectory, err = ioutil.TempDir(o.MountDir, "fifo")
+		if err != nil {
+			mu = fifo.Catch(mu, err)
+			return
+		}
+
+		defer func() {
+			mu.Append(os.RemoveAll(t.MountDirectory))
+		}()
+	}
+
 	c, err := fifo.NewCommand(t)
 	if err != nil {
 		mu = fifo.Catch(mu, err)
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/relvacode/fifo"
+	"net/url"
+	"time"
+)
+
+type S3CleanupOptions struct {
+	Prefix    *fifo.Url     `long:"s3-cleanup" value-name:"URL" description:"Instead of running a command, abort incomplete multipart uploads of objects starting with this prefix, such as s3://bucket/backups/"`
+	OlderThan time.Duration `long:"s3-cleanup-older-than" default:"24h" description:"Only abort uploads initiated at least this long ago"`
+	DryRun    bool          `long:"s3-cleanup-dry-run" description:"List the uploads that would be aborted without aborting them"`
+}
+
+// Cleanup aborts incomplete multipart uploads left behind by runs that did not finish.
+func (o S3CleanupOptions) Cleanup(p *fifo.S3Provider) error {
+	u := (*url.URL)(o.Prefix)
+
+	if !isS3(u) {
+		return errors.Errorf("%q is not an S3 URL", u.String())
+	}
+
+	uploads, err := p.ListUploads(u, time.Now().Add(-o.OlderThan))
+	if err != nil {
+		return err
+	}
+
+	mu := new(fifo.MultiError)
+	for _, upload := range uploads {
+		fmt.Printf("%s\ts3://%s/%s\t%s\n", upload.Initiated.Format(time.RFC3339), u.Host, upload.Key, upload.UploadID)
+		if o.DryRun {
+			continue
+		}
+		mu.Append(errors.Wrapf(p.AbortUpload(u, upload), "unable to abort upload of %q", upload.Key))
+	}
+	return mu.AsError()
+}
+
+// isS3 returns true if the URL is handled by the S3 provider.
+func isS3(u *url.URL) bool {
+	for _, s := range (&fifo.S3Provider{}).Schema() {
+		if s == u.Scheme {
+			return true
+		}
+	}
+	return false
+}
@@ -5,6 +5,8 @@ import (
 	"github.com/pkg/errors"
 	"golang.org/x/sync/errgroup"
 	"os/exec"
+	"strconv"
+	"strings"
 	"syscall"
 )
 
@@ -18,12 +20,51 @@ type Command struct {
 	t *Task
 }
 
-func destroyWhenError(mu *MultiError, targets ...WriteDestroyCloser) {
-	if mu != nil && len(mu.err) > 0 {
+// ExitCodes is a set of exit codes of a command.
+type ExitCodes []int
+
+// UnmarshalFlag implements un-marshalling a comma separated list of exit codes.
+func (c *ExitCodes) UnmarshalFlag(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		code, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || code < 0 || code > 255 {
+			return errors.Errorf("invalid exit code %q", v)
+		}
+		*c = append(*c, code)
+	}
+	return nil
+}
+
+// Success returns true if the exit code is a member of the set.
+// An empty set contains only the exit code 0.
+func (c ExitCodes) Success(code int) bool {
+	if len(c) == 0 {
+		return code == 0
+	}
+	for _, x := range c {
+		if x == code {
+			return true
+		}
+	}
+	return false
+}
+
+// finalize commits the targets if the command succeeded, or if targets should be preserved on failure.
+// Otherwise, or if any target cannot be committed, the targets are destroyed.
+func finalize(mu *MultiError, success, preserve bool, targets ...WriteDestroyCloser) {
+	success = success && len(mu.Errors()) == 0
+	if success || preserve {
 		for _, tg := range targets {
-			mu = Catch(mu, tg.Destroy())
+			mu.Append(errors.Wrap(tg.Commit(), "unable to commit target"))
+		}
+		if len(mu.Errors()) == 0 || preserve {
+			return
 		}
 	}
+
+	for _, tg := range targets {
+		mu.Append(tg.Destroy())
+	}
 }
 
 // wait for a given command to finish and collect its exit code.
@@ -53,22 +94,29 @@ func (c *Command) Start(ctx context.Context) (code int, mu *MultiError) {
 	args, err := gen.Replace(c.t.Call.Args)
 	if err != nil {
 		mu.Append(err)
+		// Release the pipes of arguments compiled before the failure
+		mu.CatchMulti(gen.Abandon)
 		return
 	}
 
 	defer mu.CatchMulti(gen.Sources.Teardown)
 
-	// Destroy any created targets on failure
+	// Spooled targets are read when committed so pipes are removed afterwards
+	defer mu.CatchMulti(gen.Targets.Teardown)
+
+	// Commit all targets once the command has completed successfully, otherwise destroy them
+	var (
+		targets []WriteDestroyCloser
+		success bool
+	)
+	for _, tg := range gen.Targets {
+		targets = append(targets, tg.Stream)
+	}
+
 	defer func() {
-		if len(gen.Targets) > 0 && !c.t.Preserve {
-			for _, tg := range gen.Targets {
-				destroyWhenError(mu, tg.Stream)
-			}
-		}
+		finalize(mu, success, c.t.Preserve, targets...)
 	}()
 
-	defer mu.CatchMulti(gen.Targets.Teardown)
-
 	stdin, err := c.t.SetupInput()
 	if err != nil {
 		mu.Append(errors.Wrap(err, "unable to setup input"))
@@ -84,8 +132,7 @@ func (c *Command) Start(ctx context.Context) (code int, mu *MultiError) {
 		return
 	}
 
-	// Destroy stdout and stderr on error
-	defer destroyWhenError(mu, stdout, stderr)
+	targets = append(targets, stdout, stderr)
 
 	// Close stdout and stderr when done
 	defer mu.Catch(stdout.Close, stderr.Close)
@@ -99,23 +146,47 @@ func (c *Command) Start(ctx context.Context) (code int, mu *MultiError) {
 		p.Dir = c.t.Call.WorkingDirectory
 	}
 
+	// Stop waiting for the command to open pipes once it has exited, or if the command cannot be started
+	exited := make(chan struct{})
+	wd := Watchdog{
+		Exited:  exited,
+		Timeout: c.t.OpenTimeout,
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	g, ctx := errgroup.WithContext(ctx)
 
 	if len(gen.Targets) > 0 {
 		// the named pipe for receiving data from the command needs to be setup before the command starts
 		g.Go(func() error {
-			return gen.Targets.Copy(ctx)
+			return gen.Targets.Copy(ctx, wd)
 		})
 	}
 
-	if mu.Catch(p.Start) {
+	p.ExtraFiles = gen.Files
+
+	err = p.Start()
+
+	// The command has its own copy of any inherited pipes
+	mu.CatchMulti(gen.CloseFiles)
+	if err != nil {
+		mu.Append(err)
 		return
 	}
 
+	// wait for the command to complete and capture the error code
+	var werr error
+	go func() {
+		code, werr = wait(p)
+		close(exited)
+	}()
+
 	if len(gen.Sources) > 0 {
 		// named pipe for writing data to the command needs to be setup after the command starts
 		g.Go(func() error {
-			return gen.Sources.Copy(ctx)
+			return gen.Sources.Copy(ctx, wd)
 		})
 	}
 
@@ -124,11 +195,17 @@ func (c *Command) Start(ctx context.Context) (code int, mu *MultiError) {
 
 	// If we had errors processing IO then signal the process to prematurely SIGTERM
 	if len(mu.Errors()) > 0 {
-		mu.Append(p.Process.Signal(syscall.SIGTERM))
+		select {
+		case <-exited:
+		default:
+			mu.Append(p.Process.Signal(syscall.SIGTERM))
+		}
 	}
 
-	// wait for the command to complete and capture the error code
-	code, err = wait(p)
-	mu.Append(err)
+	<-exited
+	mu.Append(werr)
+
+	// An exit code not considered successful fails the task without being an error of its own
+	success = c.t.SuccessCodes.Success(code)
 	return
 }
@@ -0,0 +1,64 @@
+package fifo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExitCodesUnmarshalFlag(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    ExitCodes
+		wantErr bool
+	}{
+		{value: "0", want: ExitCodes{0}},
+		{value: "0,2", want: ExitCodes{0, 2}},
+		{value: "0, 1, 24", want: ExitCodes{0, 1, 24}},
+		{value: "255", want: ExitCodes{255}},
+		{value: "256", wantErr: true},
+		{value: "-1", wantErr: true},
+		{value: "1-3", wantErr: true},
+		{value: "0,", wantErr: true},
+		{value: "", wantErr: true},
+		{value: "abc", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			var got ExitCodes
+			err := got.UnmarshalFlag(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UnmarshalFlag(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("UnmarshalFlag(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExitCodesSuccess(t *testing.T) {
+	tests := []struct {
+		name  string
+		codes ExitCodes
+		code  int
+		want  bool
+	}{
+		{name: "default zero", code: 0, want: true},
+		{name: "default non-zero", code: 1, want: false},
+		{name: "default signal", code: -1, want: false},
+		{name: "member", codes: ExitCodes{0, 2}, code: 2, want: true},
+		{name: "first member", codes: ExitCodes{0, 2}, code: 0, want: true},
+		{name: "not member", codes: ExitCodes{0, 2}, code: 1, want: false},
+		{name: "zero not member", codes: ExitCodes{1}, code: 0, want: false},
+		{name: "highest", codes: ExitCodes{255}, code: 255, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.codes.Success(tt.code); got != tt.want {
+				t.Errorf("%v.Success(%d) = %v, want %v", tt.codes, tt.code, got, tt.want)
+			}
+		})
+	}
+}
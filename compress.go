@@ -0,0 +1,141 @@
+package fifo
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"github.com/ulikunitz/xz"
+	"io"
+	"io/ioutil"
+)
+
+// compressors creates a compressing writer for each supported compression format.
+var compressors = map[string]func(io.Writer) (io.WriteCloser, error){
+	"gzip": func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriter(w), nil
+	},
+	"zstd": func(w io.Writer) (io.WriteCloser, error) {
+		return zstd.NewWriter(w)
+	},
+	"bzip2": func(w io.Writer) (io.WriteCloser, error) {
+		return bzip2.NewWriter(w, nil)
+	},
+	"xz": func(w io.Writer) (io.WriteCloser, error) {
+		return xz.NewWriter(w)
+	},
+}
+
+// decompressors creates a decompressing reader for each supported compression format.
+var decompressors = map[string]func(io.Reader) (io.ReadCloser, error){
+	"gzip": func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	},
+	"zstd": func(r io.Reader) (io.ReadCloser, error) {
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	},
+	"bzip2": func(r io.Reader) (io.ReadCloser, error) {
+		return bzip2.NewReader(r, nil)
+	},
+	"xz": func(r io.Reader) (io.ReadCloser, error) {
+		d, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(d), nil
+	},
+}
+
+// magics are the leading bytes identifying each compression format.
+var magics = []struct {
+	name  string
+	magic []byte
+}{
+	{"gzip", []byte{0x1f, 0x8b}},
+	{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{"bzip2", []byte("BZh")},
+	{"xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+}
+
+// ValidCompression returns an error if the named compression format is not supported.
+// The format `auto` is valid only if decompressing.
+func ValidCompression(name string, decompress bool) error {
+	if name == "" || (decompress && name == "auto") {
+		return nil
+	}
+	if _, ok := compressors[name]; !ok {
+		return errors.Errorf("unsupported compression format %q", name)
+	}
+	return nil
+}
+
+// sniffCompression detects the compression format of a stream from its leading bytes.
+// Returns an empty string if the stream is not compressed with a known format.
+func sniffCompression(r *bufio.Reader) string {
+	for _, m := range magics {
+		b, _ := r.Peek(len(m.magic))
+		if bytes.Equal(b, m.magic) {
+			return m.name
+		}
+	}
+	return ""
+}
+
+// Decompress wraps a source stream with a reader decompressing the given format.
+// An empty format returns the source stream unchanged.
+// The format `auto` detects the compression format from the beginning of the stream,
+// returning an uncompressed stream as-is.
+func Decompress(r io.ReadCloser, format string) (io.ReadCloser, error) {
+	err := ValidCompression(format, true)
+	if err != nil || format == "" {
+		return r, err
+	}
+
+	var br io.Reader = r
+	if format == "auto" {
+		buf := bufio.NewReader(r)
+		br = buf
+		format = sniffCompression(buf)
+		if format == "" {
+			return &LayeredReader{
+				Reader: buf,
+				Source: r,
+			}, nil
+		}
+	}
+
+	d, err := decompressors[format](br)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read %s stream", format)
+	}
+
+	return &LayeredReader{
+		Reader: d,
+		Source: r,
+	}, nil
+}
+
+// Compress wraps a target stream with a writer compressing to the given format.
+// An empty format returns the target stream unchanged.
+func Compress(w WriteDestroyCloser, format string) (WriteDestroyCloser, error) {
+	err := ValidCompression(format, false)
+	if err != nil || format == "" {
+		return w, err
+	}
+
+	c, err := compressors[format](w)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LayeredWriter{
+		WriteCloser: c,
+		Target:      w,
+	}, nil
+}
@@ -0,0 +1,182 @@
+package fifo
+
+import (
+	"bytes"
+	"filippo.io/age"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strings"
+)
+
+// Keys are the age recipients used to encrypt targets and identities used to decrypt sources.
+type Keys struct {
+	Recipients []age.Recipient
+	Identities []age.Identity
+}
+
+func readPassphrase(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to read passphrase")
+	}
+
+	passphrase := strings.TrimRight(string(b), "\r\n")
+	if passphrase == "" {
+		return "", errors.Errorf("passphrase file %q is empty", path)
+	}
+	return passphrase, nil
+}
+
+// ParseRecipients parses age recipients from public keys, files containing public keys, and a passphrase file.
+// A passphrase cannot be combined with other recipients.
+func ParseRecipients(keys, files []string, passphraseFile string) ([]age.Recipient, error) {
+	var recipients []age.Recipient
+	for _, k := range keys {
+		r, err := age.ParseX25519Recipient(k)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid recipient %q", k)
+		}
+		recipients = append(recipients, r)
+	}
+
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read recipients")
+		}
+		r, err := age.ParseRecipients(bytes.NewReader(b))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid recipients file %q", f)
+		}
+		recipients = append(recipients, r...)
+	}
+
+	if passphraseFile != "" {
+		if len(recipients) > 0 {
+			return nil, errors.New("a passphrase cannot be combined with other recipients")
+		}
+		passphrase, err := readPassphrase(passphraseFile)
+		if err != nil {
+			return nil, err
+		}
+		r, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, r)
+	}
+
+	return recipients, nil
+}
+
+// ParseIdentities parses age identities from identity files and a passphrase file.
+func ParseIdentities(files []string, passphraseFile string) ([]age.Identity, error) {
+	var identities []age.Identity
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read identities")
+		}
+		i, err := age.ParseIdentities(bytes.NewReader(b))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid identity file %q", f)
+		}
+		identities = append(identities, i...)
+	}
+
+	if passphraseFile != "" {
+		passphrase, err := readPassphrase(passphraseFile)
+		if err != nil {
+			return nil, err
+		}
+		i, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, i)
+	}
+
+	return identities, nil
+}
+
+// TargetRecipients returns the recipients to encrypt a target with if `?encrypt` is enabled.
+// Recipients given by `?recipient`, `?recipients_file` or `?passphrase_file` replace the default recipients.
+func (k Keys) TargetRecipients(q url.Values) ([]age.Recipient, error) {
+	if !queryFlag(q, "encrypt") {
+		return nil, nil
+	}
+
+	recipients := k.Recipients
+	if len(q["recipient"]) > 0 || len(q["recipients_file"]) > 0 || q.Get("passphrase_file") != "" {
+		var err error
+		recipients, err = ParseRecipients(q["recipient"], q["recipients_file"], q.Get("passphrase_file"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(recipients) == 0 {
+		return nil, errors.New("encryption requested but no recipients were given")
+	}
+	return recipients, nil
+}
+
+// SourceIdentities returns the identities to decrypt a source with if `?decrypt` is enabled.
+// Identities given by `?identity` or `?passphrase_file` replace the default identities.
+func (k Keys) SourceIdentities(q url.Values) ([]age.Identity, error) {
+	if !queryFlag(q, "decrypt") {
+		return nil, nil
+	}
+
+	identities := k.Identities
+	if len(q["identity"]) > 0 || q.Get("passphrase_file") != "" {
+		var err error
+		identities, err = ParseIdentities(q["identity"], q.Get("passphrase_file"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(identities) == 0 {
+		return nil, errors.New("decryption requested but no identities were given")
+	}
+	return identities, nil
+}
+
+// Decrypt wraps a source stream with a reader decrypting an age encrypted stream.
+// No decryption takes place if there are no identities.
+func Decrypt(r io.ReadCloser, identities ...age.Identity) (io.ReadCloser, error) {
+	if len(identities) == 0 {
+		return r, nil
+	}
+
+	d, err := age.Decrypt(r, identities...)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to decrypt source")
+	}
+
+	return &LayeredReader{
+		Reader: d,
+		Source: r,
+	}, nil
+}
+
+// Encrypt wraps a target stream with a writer encrypting to the given age recipients.
+// No encryption takes place if there are no recipients.
+func Encrypt(w WriteDestroyCloser, recipients ...age.Recipient) (WriteDestroyCloser, error) {
+	if len(recipients) == 0 {
+		return w, nil
+	}
+
+	e, err := age.Encrypt(w, recipients...)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to encrypt target")
+	}
+
+	return &LayeredWriter{
+		WriteCloser: e,
+		Target:      w,
+	}, nil
+}
@@ -1,6 +1,9 @@
 package fifo
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 func Catch(mu *MultiError, err ...error) *MultiError {
 	if err == nil || len(err) == 0 {
@@ -65,8 +68,17 @@ func (mu *MultiError) CatchMulti(funcs ...ErrorFunc) (failure bool) {
 	return
 }
 
+// Error returns the message of a single error, or the messages of all errors joined together.
 func (mu *MultiError) Error() string {
-	return fmt.Sprintf("(%d) errors", len(mu.err))
+	if len(mu.err) == 1 {
+		return mu.err[0].Error()
+	}
+
+	messages := make([]string, len(mu.err))
+	for i, err := range mu.err {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("(%d) errors: %s", len(mu.err), strings.Join(messages, "; "))
 }
 
 func (mu *MultiError) Errors() []error {
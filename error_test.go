@@ -0,0 +1,28 @@
+package fifo
+
+import (
+	"errors"
+	pkgerrors "github.com/pkg/errors"
+	"testing"
+)
+
+func TestMultiErrorError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "single", err: Catch(nil, errors.New("not found")), want: "not found"},
+		{name: "multiple", err: Catch(nil, errors.New("a"), nil, errors.New("b")), want: "(2) errors: a; b"},
+		{name: "nested", err: Catch(nil, Catch(nil, errors.New("a")), errors.New("b")), want: "(2) errors: a; b"},
+		{name: "wrapped", err: pkgerrors.Wrap(Catch(nil, errors.New("unexpected EOF")), "source in"), want: "source in: unexpected EOF"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.err.Error(); got != tt.want {
+				t.Errorf("Error() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
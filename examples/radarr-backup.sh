@@ -30,7 +30,7 @@ find_backup() {
 
 export_backup() {
         BACKUP_FILE="$(find_backup)"
-        fifo --stdout="s3://${1}/$(basename ${BACKUP_FILE})" -- $(request "${BACKUP_FILE}")
+        fifo --http-header "x-api-key:${RADARR_API_KEY}" --stdin "${RADARR_URL}${BACKUP_FILE}" --stdout="s3://${1}/$(basename ${BACKUP_FILE})" -- cat
 }
 
 function join { local IFS="/"; echo "$*"; }
@@ -0,0 +1,207 @@
+package fifo
+
+import (
+	"cloud.google.com/go/storage"
+	"context"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"google.golang.org/api/option"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// GCSPutObject streams an object to Google Cloud Storage using a resumable upload.
+type GCSPutObject struct {
+	p      GCSProvider
+	u      *url.URL
+	c      *storage.Client
+	w      *storage.Writer
+	cancel context.CancelFunc
+
+	committed bool
+	// closed is set once the writer and client of the upload have been closed
+	closed bool
+}
+
+func (o *GCSPutObject) Write(b []byte) (int, error) {
+	return o.w.Write(b)
+}
+
+// Close does nothing, as the object is created only once the upload is finished when committed.
+func (o *GCSPutObject) Close() error {
+	return nil
+}
+
+// Commit finishes the upload, creating the object.
+// The client of the upload is closed, a new client is used to verify or delete the object.
+func (o *GCSPutObject) Commit() error {
+	werr := o.w.Close()
+	o.closed = true
+
+	// The object is created once the writer is closed, even if closing the client fails
+	o.committed = werr == nil
+	return Catch(nil, werr, o.c.Close()).AsError()
+}
+
+// Checksum verifies the MD5 digest of the uploaded object and records the SHA-256 digest as object metadata.
+func (o *GCSPutObject) Checksum(d Digests) error {
+	attrs := o.w.Attrs()
+	if len(attrs.MD5) > 0 {
+		err := Digests{"md5": attrs.MD5}.Verify(Digests{"md5": d["md5"]})
+		if err != nil {
+			return err
+		}
+	}
+
+	c, err := o.p.Client(context.Background())
+	if err != nil {
+		return err
+	}
+
+	defer c.Close()
+
+	_, err = c.Bucket(o.u.Host).Object(gcsObjectName(o.u)).Update(context.Background(), storage.ObjectAttrsToUpdate{
+		Metadata: map[string]string{
+			"sha256": hex.EncodeToString(d["sha256"]),
+		},
+	})
+	return err
+}
+
+// Destroy cancels the upload if it has not been committed, otherwise the object is deleted.
+func (o *GCSPutObject) Destroy() error {
+	o.cancel()
+	if !o.committed {
+		if o.closed {
+			// A failed commit did not create the object
+			return nil
+		}
+		// Closing a cancelled writer discards the upload
+		o.closed = true
+		_ = o.w.Close()
+		return o.c.Close()
+	}
+
+	c, err := o.p.Client(context.Background())
+	if err != nil {
+		return err
+	}
+
+	defer c.Close()
+
+	err = c.Bucket(o.u.Host).Object(gcsObjectName(o.u)).Delete(context.Background())
+	if err == storage.ErrObjectNotExist {
+		return nil
+	}
+	return err
+}
+
+// GCSProvider provides objects from Google Cloud Storage.
+//
+// If the STORAGE_EMULATOR_HOST environment variable is set then requests are sent,
+// without authentication, to that host instead.
+type GCSProvider struct {
+	// Retry is used to resume reading an object after a transient error unless overridden by the URL.
+	Retry RetryPolicy
+}
+
+func (GCSProvider) Schema() []string {
+	return []string{"gs"}
+}
+
+func gcsObjectName(u *url.URL) string {
+	return strings.TrimPrefix(u.Path, "/")
+}
+
+// Client creates a new Google Cloud Storage client.
+func (p GCSProvider) Client(ctx context.Context) (*storage.Client, error) {
+	var opts []option.ClientOption
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		opts = append(opts,
+			option.WithoutAuthentication(),
+			option.WithEndpoint(strings.TrimSuffix(host, "/")+"/storage/v1/"),
+		)
+	}
+
+	return storage.NewClient(ctx, opts...)
+}
+
+func (p GCSProvider) Read(u *url.URL) (io.ReadCloser, error) {
+	policy, err := p.Retry.Override(u.Query())
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := p.Client(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	o := c.Bucket(u.Host).Object(gcsObjectName(u))
+	r, err := NewResumableReader(policy, func(ctx context.Context, offset int64) (io.ReadCloser, string, error) {
+		r, err := o.NewRangeReader(ctx, offset, -1)
+		if err != nil {
+			return nil, "", err
+		}
+
+		return r, strconv.FormatInt(r.Attrs.Generation, 10), nil
+	})
+	if err != nil {
+		return nil, Catch(nil, err, c.Close()).AsError()
+	}
+
+	return &gcsReader{
+		ResumableReader: r,
+		c:               c,
+	}, nil
+}
+
+// gcsReader reads an object, closing its client once closed.
+type gcsReader struct {
+	*ResumableReader
+	c *storage.Client
+}
+
+func (r *gcsReader) Close() error {
+	return Catch(nil, r.ResumableReader.Close(), r.c.Close()).AsError()
+}
+
+func (p GCSProvider) Write(u *url.URL) (WriteDestroyCloser, error) {
+	q := u.Query()
+
+	chunkSize := -1
+	if v := q.Get("chunk_size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, errors.Errorf("invalid chunk_size option %q", v)
+		}
+		chunkSize = n
+	}
+
+	c, err := p.Client(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	w := c.Bucket(u.Host).Object(gcsObjectName(u)).NewWriter(ctx)
+	w.ContentType = q.Get("type")
+	if chunkSize >= 0 {
+		w.ChunkSize = chunkSize
+	}
+
+	return &GCSPutObject{
+		p:      p,
+		u:      u,
+		c:      c,
+		w:      w,
+		cancel: cancel,
+	}, nil
+}
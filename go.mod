@@ -3,11 +3,19 @@ module github.com/relvacode/fifo
 go 1.12
 
 require (
+	cloud.google.com/go/storage v1.10.0
+	filippo.io/age v1.0.0
+	github.com/Azure/azure-storage-blob-go v0.8.0
+	github.com/Azure/go-autorest/autorest/adal v0.8.0 // indirect
 	github.com/aws/aws-sdk-go v1.19.42
+	github.com/dsnet/compress v0.0.1
 	github.com/jessevdk/go-flags v1.4.0
+	github.com/klauspost/compress v1.9.8
 	github.com/pkg/errors v0.8.1
-	github.com/stretchr/testify v1.3.0 // indirect
+	github.com/pkg/sftp v1.10.1
+	github.com/ulikunitz/xz v0.5.7
 	github.com/valyala/fasttemplate v1.0.1
-	golang.org/x/net v0.0.0-20190603091049-60506f45cf65 // indirect
-	golang.org/x/sync v0.0.0-20190423024810-112230192c58
+	golang.org/x/crypto v0.0.0-20210817164053-32db794688a5
+	golang.org/x/sync v0.0.0-20200317015054-43a5402ce75a
+	google.golang.org/api v0.28.0
 )
@@ -0,0 +1,449 @@
+package fifo
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// httpOptions are query options used by fifo which are not sent to the HTTP server.
+var httpOptions = []string{"method", "type", "destroy", "accept", "auth", "retries", "retry_backoff", "range"}
+
+// httpHeaderOption is the prefix of query options setting a request header, such as `?header.X-Api-Key=secret`.
+const httpHeaderOption = "header."
+
+// httpQueryOptions returns the query options of a URL which should not be sent to the HTTP server.
+func httpQueryOptions(u *url.URL) []string {
+	options := append([]string(nil), httpOptions...)
+	for k := range u.Query() {
+		if strings.HasPrefix(k, httpHeaderOption) {
+			options = append(options, k)
+		}
+	}
+	return options
+}
+
+// httpErrorBodyLimit is the maximum number of bytes of a response body included in an HTTPStatusError.
+const httpErrorBodyLimit = 512
+
+// HTTPStatusError is returned when an HTTP server responds with an unexpected status code.
+type HTTPStatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Status     string
+	// Body is the beginning of the response body, up to 512 bytes.
+	Body string
+}
+
+func (e *HTTPStatusError) Error() string {
+	msg := fmt.Sprintf("%s %s: unexpected response status %s", e.Method, e.URL, e.Status)
+	if e.Body != "" {
+		msg += fmt.Sprintf(": %q", e.Body)
+	}
+	return msg
+}
+
+func newHTTPStatusError(resp *http.Response) *HTTPStatusError {
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, httpErrorBodyLimit))
+
+	return &HTTPStatusError{
+		Method:     resp.Request.Method,
+		URL:        resp.Request.URL.Host + resp.Request.URL.Path,
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Body:       strings.TrimSpace(string(b)),
+	}
+}
+
+// httpAcceptCodes parses the `?accept` option of a URL,
+// a comma separated list of non-2xx status codes that should be treated as a successful response.
+func httpAcceptCodes(q url.Values) (map[int]bool, error) {
+	accept := make(map[int]bool)
+	for _, v := range q["accept"] {
+		for _, c := range strings.Split(v, ",") {
+			code, err := strconv.Atoi(strings.TrimSpace(c))
+			if err != nil || code < 100 || code > 599 {
+				return nil, errors.Errorf("invalid accepted status code %q", c)
+			}
+			accept[code] = true
+		}
+	}
+	return accept, nil
+}
+
+// HTTPPutObject streams the content written to it as the body of an HTTP request.
+type HTTPPutObject struct {
+	p       *HTTPProvider
+	u       *url.URL
+	destroy bool
+
+	g *errgroup.Group
+	// done is closed once the upload request has finished
+	done chan struct{}
+	pw   *io.PipeWriter
+
+	// reported is set once the response to the upload request has been returned by Commit
+	reported  bool
+	committed bool
+}
+
+func (o *HTTPPutObject) Write(b []byte) (int, error) {
+	return o.pw.Write(b)
+}
+
+// Close does nothing, as the request body is only finished when committed.
+func (o *HTTPPutObject) Close() error {
+	return nil
+}
+
+// Commit finishes the request body and waits for the response to the upload request.
+func (o *HTTPPutObject) Commit() error {
+	mu := new(MultiError)
+	mu.Catch(o.pw.Close)
+	mu.Append(o.g.Wait())
+	o.reported = true
+	if len(mu.Errors()) > 0 {
+		return mu.AsError()
+	}
+
+	o.committed = true
+	return nil
+}
+
+// Destroy aborts the upload request if it has not been committed,
+// returning the response to a request which finished early unless already returned by Commit.
+// Otherwise a DELETE request is issued for the uploaded object if `?destroy` is enabled.
+func (o *HTTPPutObject) Destroy() error {
+	if !o.committed {
+		if o.reported {
+			return nil
+		}
+
+		select {
+		case <-o.done:
+			// The request finished before the upload, such as when rejected by the server
+			return o.g.Wait()
+		default:
+		}
+
+		_ = o.pw.CloseWithError(errors.New("upload aborted"))
+		_ = o.g.Wait()
+		return nil
+	}
+
+	if !o.destroy {
+		return nil
+	}
+
+	resp, err := o.p.Do(context.Background(), http.MethodDelete, o.u, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// HTTPProvider provides a source from an HTTP url
+type HTTPProvider struct {
+	Client *http.Client
+	// Header is sent with every request in addition to any `?header.<name>` options of a URL.
+	Header http.Header
+
+	// Token is a bearer token used to authenticate requests to AuthHost.
+	Token string
+	// Username and Password are the basic authentication credentials used when no token is set.
+	Username string
+	Password string
+	// AuthHost is the host, optionally with a port, that the token or basic authentication credentials are sent to using HTTPS.
+	// They are sent to any other URL only if it enables `?auth`.
+	AuthHost string
+	// Netrc is the path of a netrc file used to find credentials for a host when no other credentials are given.
+	Netrc string
+	// Retry is used to resume reading a response after a transient error unless overridden by the URL.
+	Retry RetryPolicy
+}
+
+// NewHTTPClient creates an HTTP client trusting the certificate authorities in the PEM encoded caBundle
+// in addition to the system roots.
+// If certFile and keyFile are given then the client authenticates itself with that certificate.
+func NewHTTPClient(caBundle, certFile, keyFile string) (*http.Client, error) {
+	if caBundle == "" && certFile == "" && keyFile == "" {
+		return http.DefaultClient, nil
+	}
+
+	config := new(tls.Config)
+
+	if caBundle != "" {
+		pem, err := ioutil.ReadFile(caBundle)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read CA bundle")
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates found in CA bundle %q", caBundle)
+		}
+		config.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load client certificate")
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			TLSClientConfig:       config,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+	}, nil
+}
+
+func (p *HTTPProvider) Schema() []string {
+	return []string{"http", "https"}
+}
+
+// authHost reports whether the token or basic authentication credentials of the provider may be sent to a URL.
+func (p *HTTPProvider) authHost(u *url.URL) bool {
+	if queryFlag(u.Query(), "auth") {
+		return true
+	}
+	if p.AuthHost == "" || u.Scheme != "https" {
+		return false
+	}
+	return strings.EqualFold(u.Host, p.AuthHost) || strings.EqualFold(u.Hostname(), p.AuthHost)
+}
+
+// authorize sets the credentials of a request for the given URL.
+// Credentials given in the URL of the request take precedence over those configured by the provider.
+func (p *HTTPProvider) authorize(req *http.Request, u *url.URL) error {
+	if req.URL.User != nil {
+		return nil
+	}
+
+	switch {
+	case p.Token != "" && p.authHost(u):
+		req.Header.Set("Authorization", "Bearer "+p.Token)
+	case p.Username != "" && p.authHost(u):
+		req.SetBasicAuth(p.Username, p.Password)
+	case p.Netrc != "":
+		m, ok, err := netrcLookup(p.Netrc, req.URL.Hostname())
+		if err != nil {
+			return errors.Wrap(err, "unable to read netrc")
+		}
+		if ok {
+			req.SetBasicAuth(m.Login, m.Password)
+		}
+	}
+
+	return nil
+}
+
+// Do sends an HTTP request for the given URL.
+// A response with a non-2xx status code not listed in the `?accept` option of the URL is returned as an *HTTPStatusError.
+func (p *HTTPProvider) Do(ctx context.Context, method string, u *url.URL, header http.Header, body io.Reader) (*http.Response, error) {
+	accept, err := httpAcceptCodes(u.Query())
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(method, withoutQuery(u, httpQueryOptions(u)...).String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", "fifo/0.1a")
+
+	err = p.authorize(req, u)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range p.Header {
+		req.Header[k] = v
+	}
+	for k, v := range u.Query() {
+		if strings.HasPrefix(k, httpHeaderOption) {
+			req.Header[http.CanonicalHeaderKey(strings.TrimPrefix(k, httpHeaderOption))] = v
+		}
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if (resp.StatusCode < 200 || resp.StatusCode > 299) && !accept[resp.StatusCode] {
+		err := newHTTPStatusError(resp)
+		_ = resp.Body.Close()
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// httpVersion identifies the content of a response using its ETag or Last-Modified header.
+// Weak ETags cannot be used to resume a response and are ignored.
+func httpVersion(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+func (p *HTTPProvider) Read(u *url.URL) (io.ReadCloser, error) {
+	policy, err := p.Retry.Override(u.Query())
+	if err != nil {
+		return nil, err
+	}
+
+	rng, err := byteRange(u.Query())
+	if err != nil {
+		return nil, err
+	}
+
+	return NewResumableReader(policy, func(ctx context.Context, offset int64) (io.ReadCloser, string, error) {
+		header := make(http.Header)
+		switch {
+		case rng != nil:
+			header.Set("Range", rng.Header(offset))
+		case offset > 0:
+			header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+
+		resp, err := p.Do(ctx, http.MethodGet, u, header, nil)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if rng != nil {
+			if resp.StatusCode != http.StatusPartialContent {
+				err = errors.Errorf("server did not respond with the requested range %s", header.Get("Range"))
+			} else {
+				err = rng.Served(offset, resp.Header.Get("Content-Range"))
+			}
+			if err != nil {
+				_ = resp.Body.Close()
+				return nil, "", err
+			}
+		} else if offset > 0 && (resp.StatusCode != http.StatusPartialContent ||
+			!strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset))) {
+			_ = resp.Body.Close()
+			return nil, "", errors.Errorf("server did not respond with the requested range starting at %d", offset)
+		}
+
+		return resp.Body, httpVersion(resp), nil
+	})
+}
+
+// Stat returns the size and version of a response using a HEAD request.
+// The size is -1 if the server does not support range requests.
+func (p *HTTPProvider) Stat(u *url.URL) (int64, string, error) {
+	resp, err := p.Do(context.Background(), http.MethodHead, u, nil, nil)
+	if err != nil {
+		return 0, "", err
+	}
+
+	_ = resp.Body.Close()
+
+	if resp.Header.Get("Accept-Ranges") != "bytes" || resp.ContentLength < 0 {
+		return -1, "", nil
+	}
+	return resp.ContentLength, httpVersion(resp), nil
+}
+
+// ReadRange reads part of a response using a range request,
+// conditional on the ETag or Last-Modified date of the response matching the given version.
+func (p *HTTPProvider) ReadRange(u *url.URL, version string, offset, length int64) (io.ReadCloser, error) {
+	header := make(http.Header)
+	header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	switch {
+	case strings.HasPrefix(version, `"`):
+		header.Set("If-Match", version)
+	case version != "":
+		header.Set("If-Unmodified-Since", version)
+	}
+
+	resp, err := p.Do(context.Background(), http.MethodGet, u, header, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusPartialContent ||
+		!strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-%d/", offset, offset+length-1)) {
+		_ = resp.Body.Close()
+		return nil, errors.Errorf("server did not respond with the requested range %d-%d", offset, offset+length-1)
+	}
+
+	return resp.Body, nil
+}
+
+func (p *HTTPProvider) Write(u *url.URL) (WriteDestroyCloser, error) {
+	q := u.Query()
+
+	method := http.MethodPut
+	if m := q.Get("method"); m != "" {
+		method = strings.ToUpper(m)
+	}
+	if method != http.MethodPut && method != http.MethodPost {
+		return nil, errors.Errorf("invalid upload method option %q", method)
+	}
+
+	if _, err := httpAcceptCodes(q); err != nil {
+		return nil, err
+	}
+
+	header := make(http.Header)
+	if t := q.Get("type"); t != "" {
+		header.Set("Content-Type", t)
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+
+	g, ctx := errgroup.WithContext(context.Background())
+	g.Go(func() error {
+		defer close(done)
+
+		resp, err := p.Do(ctx, method, u, header, pr)
+		if err == nil {
+			err = resp.Body.Close()
+		}
+		// Unblock any pending writes if the request finishes early
+		return Catch(nil, err, pr.CloseWithError(errors.New("upload request finished"))).AsError()
+	})
+
+	return &HTTPPutObject{
+		p:       p,
+		u:       u,
+		destroy: queryFlag(q, "destroy"),
+		g:       g,
+		done:    done,
+		pw:      pw,
+	}, nil
+}
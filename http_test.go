@@ -0,0 +1,177 @@
+package fifo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestHTTPAcceptCodes(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    map[int]bool
+		wantErr bool
+	}{
+		{query: "", want: map[int]bool{}},
+		{query: "accept=404", want: map[int]bool{404: true}},
+		{query: "accept=404,%20409", want: map[int]bool{404: true, 409: true}},
+		{query: "accept=404&accept=500", want: map[int]bool{404: true, 500: true}},
+		{query: "accept=abc", wantErr: true},
+		{query: "accept=99", wantErr: true},
+		{query: "accept=600", wantErr: true},
+		{query: "accept=404,", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := httpAcceptCodes(q)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("httpAcceptCodes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("httpAcceptCodes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPQueryOptions(t *testing.T) {
+	tests := []struct {
+		url  string
+		sent string
+	}{
+		{url: "https://example.com/a?x=1", sent: "https://example.com/a?x=1"},
+		{url: "https://example.com/a?method=POST&type=text/plain&retries=3", sent: "https://example.com/a"},
+		{url: "https://example.com/a?header.X-Api-Key=secret&x=1", sent: "https://example.com/a?x=1"},
+		{url: "https://example.com/a?auth&range=bytes=0-9&accept=404&destroy", sent: "https://example.com/a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := withoutQuery(u, httpQueryOptions(u)...).String()
+			if got != tt.sent {
+				t.Errorf("sent %q, want %q", got, tt.sent)
+			}
+		})
+	}
+}
+
+func TestHTTPProviderDoHeaders(t *testing.T) {
+	var got *http.Request
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	}))
+	defer ts.Close()
+
+	p := &HTTPProvider{
+		Client: ts.Client(),
+		Header: http.Header{"X-Global": []string{"global"}},
+	}
+
+	u, err := url.Parse(ts.URL + "/a?header.X-Api-Key=secret&header.x-global=override&retries=1&x=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := p.Do(context.Background(), http.MethodGet, u, http.Header{"X-Request": []string{"request"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	if q := got.URL.RawQuery; q != "x=1" {
+		t.Errorf("query = %q, want %q", q, "x=1")
+	}
+	for k, want := range map[string]string{
+		"X-Api-Key": "secret",
+		"X-Global":  "override",
+		"X-Request": "request",
+	} {
+		if v := got.Header.Get(k); v != want {
+			t.Errorf("header %s = %q, want %q", k, v, want)
+		}
+	}
+}
+
+func TestHTTPProviderAuthorize(t *testing.T) {
+	p := &HTTPProvider{
+		Token:    "token",
+		AuthHost: "dav.example.com",
+	}
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "https://dav.example.com/a", want: "Bearer token"},
+		{url: "https://DAV.example.com:443/a", want: "Bearer token"},
+		{url: "http://dav.example.com/a", want: ""},
+		{url: "https://other.example.com/a", want: ""},
+		{url: "https://other.example.com/a?auth", want: "Bearer token"},
+		{url: "http://other.example.com/a?auth=0", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req, err := http.NewRequest(http.MethodGet, withoutQuery(u, httpQueryOptions(u)...).String(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = p.authorize(req, u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := req.Header.Get("Authorization"); got != tt.want {
+				t.Errorf("Authorization = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPPutObjectRejected(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "denied", http.StatusForbidden)
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL + "/object")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := (&HTTPProvider{Client: ts.Client()}).Write(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = w.Write([]byte("content"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Commit(); err == nil {
+		t.Fatal("Commit() succeeded for a rejected upload")
+	}
+	// The response was already returned by Commit
+	if err := w.Destroy(); err != nil {
+		t.Errorf("Destroy() = %v, want nil", err)
+	}
+}
@@ -2,33 +2,166 @@ package fifo
 
 import (
 	"context"
+	"fmt"
+	"github.com/pkg/errors"
 	"golang.org/x/sync/errgroup"
 	"io"
 	"net/url"
 	"os"
+	"syscall"
+	"time"
 )
 
+// A Watchdog decides when to stop waiting for the command to open a pipe.
+type Watchdog struct {
+	// Exited is closed once the command has exited
+	Exited <-chan struct{}
+	// Timeout is how long to wait for the command to open a pipe. Zero waits until the command exits
+	Timeout time.Duration
+}
+
+// open opens a pipe, giving up if the command exits or the timeout expires before the command opens the other end.
+// The pending open of an abandoned named pipe is completed by opening the pipe for both reading and writing,
+// which never blocks.
+func (wd Watchdog) open(ctx context.Context, path string, open func() (*os.File, error)) (*os.File, error) {
+	type result struct {
+		f   *os.File
+		err error
+	}
+
+	c := make(chan result, 1)
+	go func() {
+		f, err := open()
+		c <- result{f, err}
+	}()
+
+	var timeout <-chan time.Time
+	if wd.Timeout > 0 {
+		t := time.NewTimer(wd.Timeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	var reason error
+	select {
+	case r := <-c:
+		return r.f, r.err
+	case <-wd.Exited:
+		reason = errors.New("the command exited without opening it")
+	case <-timeout:
+		reason = errors.Errorf("the command did not open it within %s", wd.Timeout)
+	case <-ctx.Done():
+		reason = ctx.Err()
+	}
+
+	// The command may have opened the pipe just before exiting
+	select {
+	case r := <-c:
+		return r.f, r.err
+	default:
+	}
+
+	u, err := os.OpenFile(path, os.O_RDWR, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(reason, "unable to unblock pipe: %v", err)
+	}
+
+	r := <-c
+	if r.f != nil {
+		_ = r.f.Close()
+	}
+	_ = u.Close()
+	return nil, reason
+}
+
+// drained waits for the command to exit and returns an error if the command left data unread in a pipe,
+// then closes the pipe.
+func (wd Watchdog) drained(ctx context.Context, f *os.File) error {
+	select {
+	case <-wd.Exited:
+	case <-ctx.Done():
+		return f.Close()
+	}
+
+	n, err := unreadBytes(f)
+	if err == nil && n > 0 {
+		err = errors.New("the command did not read all of it")
+	}
+	return Catch(nil, err, f.Close()).AsError()
+}
+
+// describePipe describes a pipe in errors by its tag and URL.
+// Credentials and options are removed from the URL.
+func describePipe(kind, name string, u *url.URL) string {
+	c := *u
+	c.User = nil
+	c.RawQuery = ""
+	return fmt.Sprintf("%s %q (%s)", kind, name, c.String())
+}
+
+// isBrokenPipe returns true if an error writes to a pipe no longer open for reading.
+func isBrokenPipe(err error) bool {
+	pe, ok := err.(*os.PathError)
+	return ok && pe.Err == syscall.EPIPE
+}
+
 type SourcePipe struct {
 	Name   string
 	Path   string
 	URL    *url.URL
 	Stream io.ReadCloser
+	// Spooled is true if the source has been downloaded to a regular file at Path, so there is no stream to copy.
+	Spooled bool
+
+	// Pipe and Child are the ends of an anonymous pipe, where Child is inherited by the command.
+	// Both are nil for a named pipe.
+	Pipe  *os.File
+	Child *os.File
+}
+
+func (s *SourcePipe) open() (*os.File, error) {
+	if s.Pipe != nil {
+		return s.Pipe, nil
+	}
+	return os.OpenFile(s.Path, os.O_WRONLY|os.O_APPEND, 0600)
 }
 
 type Sources []*SourcePipe
 
-// Copy starts copying data from each source stream to each existing named pipe
-func (s Sources) Copy(ctx context.Context) error {
+// Copy starts copying data from each source stream to each existing pipe once the pipe is opened by the command.
+func (s Sources) Copy(ctx context.Context, wd Watchdog) error {
 	g, ctx := errgroup.WithContext(ctx)
 	for _, src := range s {
-		pipe, err := os.OpenFile(src.Path, os.O_WRONLY|os.O_APPEND, 0600)
-		if err != nil {
-			return err
+		src := src
+		if src.Spooled {
+			continue
 		}
 
 		g.Go(func() error {
-			_, err := io.Copy(pipe, src.Stream)
-			return Catch(nil, err, pipe.Close(), src.Stream.Close()).AsError()
+			name := describePipe("source", src.Name, src.URL)
+			pipe, err := wd.open(ctx, src.Path, src.open)
+			if err != nil {
+				return Catch(nil, errors.Wrapf(err, "unable to open %s", name), src.Stream.Close()).AsError()
+			}
+
+			var held *os.File
+			_, err = io.Copy(pipe, src.Stream)
+			if err == nil {
+				// A source smaller than the pipe buffer is copied even if the command never reads it,
+				// so keep the pipe readable to find any data left in it once the command exits
+				held, err = holdPipe(pipe)
+			}
+			if isBrokenPipe(err) {
+				err = errors.New("the command did not read all of it")
+			}
+			err = Catch(nil, errors.Wrapf(err, "unable to copy %s", name), pipe.Close(), src.Stream.Close()).AsError()
+			if held == nil {
+				return err
+			}
+			if err != nil {
+				return Catch(nil, err, held.Close()).AsError()
+			}
+			return errors.Wrapf(wd.drained(ctx, held), "unable to copy %s", name)
 		})
 	}
 
@@ -37,6 +170,10 @@ func (s Sources) Copy(ctx context.Context) error {
 
 func (s Sources) Teardown() (mu *MultiError) {
 	for _, src := range s {
+		// An anonymous pipe has no file to remove
+		if src.Child != nil {
+			continue
+		}
 		mu = Catch(mu, os.Remove(src.Path))
 	}
 	return
@@ -47,21 +184,42 @@ type TargetPipe struct {
 	Path   string
 	URL    *url.URL
 	Stream WriteDestroyCloser
+	// Spooled is true if the command writes the target to a regular file at Path, so there is no stream to copy.
+	Spooled bool
+
+	// Pipe and Child are the ends of an anonymous pipe, where Child is inherited by the command.
+	// Both are nil for a named pipe.
+	Pipe  *os.File
+	Child *os.File
+}
+
+func (t *TargetPipe) open() (*os.File, error) {
+	if t.Pipe != nil {
+		return t.Pipe, nil
+	}
+	return os.OpenFile(t.Path, os.O_RDONLY, 0600)
 }
 
 type Targets []*TargetPipe
 
-func (t Targets) Copy(ctx context.Context) error {
+// Copy starts copying data from each existing pipe to each target stream once the pipe is opened by the command.
+func (t Targets) Copy(ctx context.Context, wd Watchdog) error {
 	g, ctx := errgroup.WithContext(ctx)
 	for _, tg := range t {
-		pipe, err := os.OpenFile(tg.Path, os.O_RDONLY, 0600)
-		if err != nil {
-			return err
+		tg := tg
+		if tg.Spooled {
+			continue
 		}
 
 		g.Go(func() error {
-			_, err := io.Copy(tg.Stream, pipe)
-			return Catch(nil, err, tg.Stream.Close(), pipe.Close()).AsError()
+			name := describePipe("target", tg.Name, tg.URL)
+			pipe, err := wd.open(ctx, tg.Path, tg.open)
+			if err != nil {
+				return errors.Wrapf(err, "unable to open %s", name)
+			}
+
+			_, err = io.Copy(tg.Stream, pipe)
+			return Catch(nil, errors.Wrapf(err, "unable to copy %s", name), tg.Stream.Close(), pipe.Close()).AsError()
 		})
 	}
 
@@ -70,7 +228,53 @@ func (t Targets) Copy(ctx context.Context) error {
 
 func (t Targets) Teardown() (mu *MultiError) {
 	for _, target := range t {
-		mu = Catch(mu, os.Remove(target.Path))
+		// An anonymous pipe has no file to remove
+		if target.Child != nil {
+			continue
+		}
+		err := os.Remove(target.Path)
+		// The command may not have created a spooled target
+		if target.Spooled && os.IsNotExist(err) {
+			continue
+		}
+		mu = Catch(mu, err)
 	}
 	return
 }
+
+// LayeredReader reads through a layer, such as a decompressor, from a source stream.
+// Closing the reader closes the layer, if it is an io.Closer, and then the source stream.
+type LayeredReader struct {
+	io.Reader
+	Source io.Closer
+}
+
+func (r *LayeredReader) Close() error {
+	mu := new(MultiError)
+	if c, ok := r.Reader.(io.Closer); ok {
+		mu.Catch(c.Close)
+	}
+	mu.Catch(r.Source.Close)
+	return mu.AsError()
+}
+
+// LayeredWriter writes through a layer, such as a compressor, into a target stream.
+// Closing the writer flushes and closes the layer and then the target stream.
+type LayeredWriter struct {
+	io.WriteCloser
+	Target WriteDestroyCloser
+}
+
+func (w *LayeredWriter) Close() error {
+	mu := new(MultiError)
+	mu.Catch(w.WriteCloser.Close, w.Target.Close)
+	return mu.AsError()
+}
+
+func (w *LayeredWriter) Commit() error {
+	return w.Target.Commit()
+}
+
+func (w *LayeredWriter) Destroy() error {
+	return w.Target.Destroy()
+}
@@ -0,0 +1,103 @@
+package fifo
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+)
+
+// netrcMachine is a set of credentials for a host described by a netrc file.
+type netrcMachine struct {
+	Login    string
+	Password string
+}
+
+// parseNetrc reads the credentials for each machine from a netrc file.
+// Credentials of the `default` entry are stored using an empty machine name.
+func parseNetrc(r io.Reader) (map[string]netrcMachine, error) {
+	var (
+		machines = make(map[string]netrcMachine)
+		name     string
+		current  *netrcMachine
+	)
+
+	commit := func() {
+		if current != nil {
+			machines[name] = *current
+		}
+	}
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			var value string
+			if i+1 < len(fields) {
+				value = fields[i+1]
+			}
+
+			switch fields[i] {
+			case "machine":
+				commit()
+				name, current = value, new(netrcMachine)
+				i++
+			case "default":
+				commit()
+				name, current = "", new(netrcMachine)
+			case "login":
+				if current != nil {
+					current.Login = value
+				}
+				i++
+			case "password":
+				if current != nil {
+					current.Password = value
+				}
+				i++
+			case "account":
+				i++
+			case "macdef":
+				// Macro definitions continue until the next blank line
+				commit()
+				current = nil
+				for s.Scan() && strings.TrimSpace(s.Text()) != "" {
+				}
+				i = len(fields)
+			}
+		}
+	}
+
+	commit()
+	return machines, s.Err()
+}
+
+// netrcLookup finds the credentials for a host from the netrc file at the given path.
+// A missing netrc file is not an error.
+func netrcLookup(path, host string) (m netrcMachine, ok bool, err error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return m, false, nil
+	}
+	if err != nil {
+		return m, false, err
+	}
+
+	defer f.Close()
+
+	machines, err := parseNetrc(f)
+	if err != nil {
+		return m, false, err
+	}
+
+	m, ok = machines[host]
+	if !ok {
+		m, ok = machines[""]
+	}
+	return
+}
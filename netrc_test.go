@@ -0,0 +1,81 @@
+package fifo
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseNetrc(t *testing.T) {
+	tests := []struct {
+		name  string
+		netrc string
+		want  map[string]netrcMachine
+	}{
+		{
+			name:  "empty",
+			netrc: "",
+			want:  map[string]netrcMachine{},
+		},
+		{
+			name:  "single line",
+			netrc: "machine example.com login alice password secret",
+			want: map[string]netrcMachine{
+				"example.com": {Login: "alice", Password: "secret"},
+			},
+		},
+		{
+			name: "multiple lines and machines",
+			netrc: `
+machine a.example.com
+	login alice
+	password one
+
+# a comment
+machine b.example.com login bob password two account ignored
+`,
+			want: map[string]netrcMachine{
+				"a.example.com": {Login: "alice", Password: "one"},
+				"b.example.com": {Login: "bob", Password: "two"},
+			},
+		},
+		{
+			name:  "default",
+			netrc: "machine a.example.com login alice password one\ndefault login anonymous password guest",
+			want: map[string]netrcMachine{
+				"a.example.com": {Login: "alice", Password: "one"},
+				"":              {Login: "anonymous", Password: "guest"},
+			},
+		},
+		{
+			name: "macdef is skipped until a blank line",
+			netrc: `machine a.example.com login alice password one
+macdef init
+machine ignored login mallory password evil
+
+machine b.example.com login bob password two
+`,
+			want: map[string]netrcMachine{
+				"a.example.com": {Login: "alice", Password: "one"},
+				"b.example.com": {Login: "bob", Password: "two"},
+			},
+		},
+		{
+			name:  "login without a machine",
+			netrc: "login alice password one",
+			want:  map[string]netrcMachine{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseNetrc(strings.NewReader(tt.netrc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseNetrc() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"math/rand"
 	"net/url"
 	"os"
+	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -83,13 +84,13 @@ type UrlMapping map[string]*Url
 // UnmarshalFlag implements un-marshalling a flag value into the URL mapping.
 // Where the format is key:url
 func (m UrlMapping) UnmarshalFlag(value string) error {
-	parts := strings.Split(value, "=")
+	parts := strings.SplitN(value, "=", 2)
 	if len(parts) < 2 {
 		return errors.Errorf("expected tag=url format of flag")
 	}
 
 	u := new(Url)
-	err := u.UnmarshalFlag(strings.Join(parts[1:], ":"))
+	err := u.UnmarshalFlag(parts[1])
 	if err != nil {
 		return err
 	}
@@ -98,11 +99,27 @@ func (m UrlMapping) UnmarshalFlag(value string) error {
 	return nil
 }
 
+// Merge adds the URLs of another mapping with the given query option enabled.
+// A tag cannot be described by both mappings.
+func (m UrlMapping) Merge(other UrlMapping, option string) error {
+	for tag, u := range other {
+		if _, ok := m[tag]; ok {
+			return errors.Errorf("tag %q described more than once", tag)
+		}
+
+		q := (*url.URL)(u).Query()
+		q.Set(option, "1")
+		u.RawQuery = q.Encode()
+		m[tag] = u
+	}
+	return nil
+}
+
 type runeWriter interface {
 	WriteRune(r rune) (n int, err error)
 }
 
-var filenameRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ01234567890_-. ")
+var filenameRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ01234567890_-.")
 var extensionRunes = []rune("abcdefghijklmnopqrstuvwxyz0123456789")
 
 func randExtensionString(w runeWriter, n int) {
@@ -112,22 +129,90 @@ func randExtensionString(w runeWriter, n int) {
 	_, _ = w.WriteRune('.')
 }
 
-// urlToFilename sanitises a URL into a name compatible with any file system.
+// sanitiseFilename writes a name compatible with any file system.
 // Specifically, any characters not a member of `filenameRunes` is replaced with `_`
-func urlToFilename(u *url.URL) string {
-	var s = u.String()
-	var b bytes.Buffer
-	randExtensionString(&b, 6)
-
+func sanitiseFilename(w runeWriter, s string) {
 scan:
 	for _, r := range s {
 		for _, xr := range filenameRunes {
 			if r == xr {
-				b.WriteRune(r)
+				_, _ = w.WriteRune(r)
 				continue scan
 			}
 		}
-		b.WriteRune('_')
+		_, _ = w.WriteRune('_')
 	}
+}
+
+// fileExtension returns the file extension of a path.
+// A `.tar` extension preceding the file extension is kept, such as in `.tar.gz`.
+// An empty path, a directory or a hidden file such as `.env` has no file extension.
+func fileExtension(p string) string {
+	base := path.Base(p)
+	ext := path.Ext(base)
+	if ext == base || base == "/" {
+		return ""
+	}
+	if ext != "" && path.Ext(strings.TrimSuffix(base, ext)) == ".tar" {
+		ext = ".tar" + ext
+	}
+	return ext
+}
+
+// numberFilename inserts a number before the file extension of a name, such as `archive-2.tar.gz`.
+func numberFilename(name string, n int) string {
+	ext := fileExtension(name)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), n, ext)
+}
+
+// A PipeNamer names the pipe created in the mount directory for a tag and its URL.
+// The name must be compatible with any file system.
+type PipeNamer interface {
+	PipeName(tag string, u *url.URL) string
+}
+
+// PipeNamers are the available pipe naming strategies by name.
+var PipeNamers = map[string]PipeNamer{
+	"random":   RandomPipeNames{},
+	"tag":      TagPipeNames{},
+	"basename": BasenamePipeNames{},
+}
+
+// RandomPipeNames names pipes after their URL with a random prefix, such as `ab12cd.s3___bucket_file.tar.gz`.
+type RandomPipeNames struct{}
+
+func (RandomPipeNames) PipeName(_ string, u *url.URL) string {
+	// Query options are not part of the name so that it ends with the file extension of the URL
+	c := *u
+	c.RawQuery = ""
+	c.Fragment = ""
+
+	var b bytes.Buffer
+	randExtensionString(&b, 6)
+	sanitiseFilename(&b, c.String())
+	return b.String()
+}
+
+// TagPipeNames names pipes after their tag with the file extension of their URL, such as `archive.tar.gz`.
+type TagPipeNames struct{}
+
+func (TagPipeNames) PipeName(tag string, u *url.URL) string {
+	var b bytes.Buffer
+	sanitiseFilename(&b, tag+fileExtension(u.Path))
+	return b.String()
+}
+
+// BasenamePipeNames names pipes after the last element of their URL path, such as `file.tar.gz`.
+// Pipes of URLs without a path are named after their tag.
+type BasenamePipeNames struct{}
+
+func (BasenamePipeNames) PipeName(tag string, u *url.URL) string {
+	base := path.Base(u.Path)
+	if base == "." || base == "/" {
+		return TagPipeNames{}.PipeName(tag, u)
+	}
+
+	var b bytes.Buffer
+	sanitiseFilename(&b, base)
 	return b.String()
 }
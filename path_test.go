@@ -0,0 +1,148 @@
+package fifo
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestNumberFilename(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+		want string
+	}{
+		{name: "in.txt", n: 2, want: "in-2.txt"},
+		{name: "in2.txt", n: 2, want: "in2-2.txt"},
+		{name: "in-2.txt", n: 2, want: "in-2-2.txt"},
+		{name: "archive.tar.gz", n: 3, want: "archive-3.tar.gz"},
+		{name: "in", n: 2, want: "in-2"},
+		{name: ".hidden", n: 2, want: ".hidden-2"},
+		{name: ".hidden.txt", n: 2, want: ".hidden-2.txt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := numberFilename(tt.name, tt.n); got != tt.want {
+				t.Errorf("numberFilename(%q, %d) = %q, want %q", tt.name, tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreatePipeNames(t *testing.T) {
+	type pipe struct {
+		tag string
+		url string
+	}
+
+	tests := []struct {
+		name     string
+		namer    PipeNamer
+		existing []string
+		pipes    []pipe
+		want     []string
+	}{
+		{
+			name:  "tag",
+			namer: TagPipeNames{},
+			pipes: []pipe{{"in", "s3://bucket/a.txt"}, {"in2", "s3://bucket/b.txt"}, {"archive", "s3://bucket/c.tar.gz"}, {"out", "s3://bucket"}, {"env", "s3://bucket/.env"}},
+			want:  []string{"in.txt", "in2.txt", "archive.tar.gz", "out", "env"},
+		},
+		{
+			name:  "tag collision",
+			namer: TagPipeNames{},
+			pipes: []pipe{{"in", "s3://bucket/a.txt"}, {"in", "s3://bucket/b.txt"}, {"in-2", "s3://bucket/c.txt"}},
+			want:  []string{"in.txt", "in-2.txt", "in-2-2.txt"},
+		},
+		{
+			name:  "tag sanitised collision",
+			namer: TagPipeNames{},
+			pipes: []pipe{{"a b", "s3://bucket/a.tar.gz"}, {"a_b", "s3://bucket/b.tar.gz"}},
+			want:  []string{"a_b.tar.gz", "a_b-2.tar.gz"},
+		},
+		{
+			name:  "basename collision",
+			namer: BasenamePipeNames{},
+			pipes: []pipe{{"a", "s3://one/in.txt"}, {"b", "s3://two/in.txt"}, {"c", "s3://three/in2.txt"}, {"d", "s3://four/in.txt"}},
+			want:  []string{"in.txt", "in-2.txt", "in2.txt", "in-3.txt"},
+		},
+		{
+			name:  "basename without path",
+			namer: BasenamePipeNames{},
+			pipes: []pipe{{"in", "s3://bucket"}, {"in", "s3://bucket/"}},
+			want:  []string{"in", "in-2"},
+		},
+		{
+			name:     "existing file",
+			namer:    BasenamePipeNames{},
+			existing: []string{"in.txt", "in-2.txt"},
+			pipes:    []pipe{{"a", "s3://bucket/in.txt"}},
+			want:     []string{"in-3.txt"},
+		},
+		{
+			name:  "tag and basename collision",
+			namer: TagPipeNames{},
+			pipes: []pipe{{"archive", "s3://bucket/a.tar.gz"}, {"archive.tar", "s3://bucket/b.gz"}},
+			want:  []string{"archive.tar.gz", "archive-2.tar.gz"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := &Task{MountDirectory: "mnt", PipeNames: tt.namer}
+			existing := make(map[string]bool)
+			for _, name := range tt.existing {
+				existing[filepath.Join("mnt", name)] = true
+			}
+
+			var got []string
+			for _, p := range tt.pipes {
+				u, err := url.Parse(p.url)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				path, err := task.createPipe(p.tag, u, func(path string) error {
+					if existing[path] {
+						return os.ErrExist
+					}
+					return nil
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, filepath.Base(path))
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pipe names = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRandomPipeNames(t *testing.T) {
+	u, err := url.Parse("s3://bucket/dir/file.tar.gz?version_id=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task := &Task{}
+	tests := []struct {
+		n    int
+		want *regexp.Regexp
+	}{
+		{n: 1, want: regexp.MustCompile(`^[a-z0-9]{6}\.s3___bucket_dir_file\.tar\.gz$`)},
+		{n: 2, want: regexp.MustCompile(`^[a-z0-9]{6}\.s3___bucket_dir_file-2\.tar\.gz$`)},
+	}
+
+	for _, tt := range tests {
+		if got := task.pipeName("in", u, tt.n); !tt.want.MatchString(got) {
+			t.Errorf("pipeName(%d) = %q, want %s", tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package fifo
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// holdPipe opens another reference to the read side of a source pipe, so that data the command did not read
+// stays in the pipe after the command exits.
+// Opening the descriptor of a pipe through /proc opens the pipe again, so this works for both named and anonymous pipes.
+// The reference is opened without blocking and never reads, so the command still sees the end of the stream
+// once the write side is closed.
+func holdPipe(pipe *os.File) (*os.File, error) {
+	return os.OpenFile(fmt.Sprintf("/proc/self/fd/%d", pipe.Fd()), os.O_RDONLY|syscall.O_NONBLOCK, 0600)
+}
+
+// unreadBytes returns the number of bytes waiting to be read from a pipe.
+func unreadBytes(f *os.File) (int, error) {
+	var n int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TIOCINQ, uintptr(unsafe.Pointer(&n)))
+	if errno != 0 {
+		return 0, os.NewSyscallError("ioctl", errno)
+	}
+	return int(n), nil
+}
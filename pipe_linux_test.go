@@ -0,0 +1,58 @@
+package fifo
+
+import (
+	"io"
+	"os"
+	"testing"
+)
+
+func TestUnreadBytes(t *testing.T) {
+	tests := []struct {
+		name    string
+		written int
+		read    int
+		want    int
+	}{
+		{name: "empty", want: 0},
+		{name: "unread", written: 10, want: 10},
+		{name: "partly read", written: 10, read: 4, want: 6},
+		{name: "read", written: 10, read: 10, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, w, err := os.Pipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+
+			_, err = w.Write(make([]byte, tt.written))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			held, err := holdPipe(w)
+			if err != nil {
+				t.Fatalf("holdPipe() error = %v", err)
+			}
+			defer held.Close()
+
+			// The command's end is closed once it has read what it needs
+			_ = w.Close()
+			_, err = io.ReadFull(r, make([]byte, tt.read))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = r.Close()
+
+			got, err := unreadBytes(held)
+			if err != nil {
+				t.Fatalf("unreadBytes() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("unreadBytes() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
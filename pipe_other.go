@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package fifo
+
+import (
+	"os"
+)
+
+// holdPipe returns nil as a pipe cannot be checked for unread data on this system.
+func holdPipe(pipe *os.File) (*os.File, error) {
+	return nil, nil
+}
+
+// unreadBytes always returns zero as a pipe cannot be checked for unread data on this system.
+func unreadBytes(f *os.File) (int, error) {
+	return 0, nil
+}
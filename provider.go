@@ -1,15 +1,10 @@
 package fifo
 
 import (
-	"context"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"crypto/sha256"
 	"github.com/pkg/errors"
-	"golang.org/x/sync/errgroup"
 	"io"
-	"net/http"
+	"io/ioutil"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -25,10 +20,16 @@ type SourceProvider interface {
 	Read(*url.URL) (io.ReadCloser, error)
 }
 
+// WriteDestroyCloser is a target stream which is finalized in two phases.
+// Close is called once all data has been written to the stream,
+// then either Commit or Destroy is called depending on whether the command succeeded.
 type WriteDestroyCloser interface {
 	io.WriteCloser
-	// Teardown is called when the command fails, signalling that the object should be removed.
-	// Closer must be closed before calling abort.
+	// Commit is called when the command succeeds, making the object visible at its destination.
+	// Closer must be closed before calling commit.
+	Commit() error
+	// Destroy is called when the command fails, signalling that the object should be removed.
+	// Closer must be closed before calling destroy.
 	Destroy() error
 }
 
@@ -72,189 +73,205 @@ func ProvideTarget(u *url.URL, providers ...Provider) (WriteDestroyCloser, error
 
 type DestroyableFile struct {
 	Path string
+	// Offset is the size of the file before it was appended to
+	Offset int64
+	// Existed is set if the file existed before it was appended to,
+	// in which case it is truncated to Offset rather than removed when destroyed
+	Existed bool
 	*os.File
 }
 
+// Commit does nothing as the file is written in place.
+func (f *DestroyableFile) Commit() error {
+	return nil
+}
+
+// Destroy removes the file, or removes the content appended to a file which already existed.
 func (f *DestroyableFile) Destroy() error {
+	if f.Existed {
+		return os.Truncate(f.Path, f.Offset)
+	}
 	return os.Remove(f.Path)
 }
 
-type FileProvider struct {
-}
+// Checksum verifies the content written to the file by reading it back from disk, starting from Offset.
+func (f *DestroyableFile) Checksum(d Digests) error {
+	r, err := os.Open(f.Path)
+	if err != nil {
+		return err
+	}
 
-func (FileProvider) Schema() []string {
-	return []string{"file"}
-}
+	defer r.Close()
 
-func (FileProvider) Target(u *url.URL) string {
-	return filepath.Join(u.Host, u.Path)
-}
+	_, err = r.Seek(f.Offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
 
-func (fp FileProvider) Read(u *url.URL) (io.ReadCloser, error) {
-	return os.OpenFile(fp.Target(u), os.O_RDONLY, os.FileMode(0644))
+	h := sha256.New()
+	_, err = io.Copy(h, r)
+	if err != nil {
+		return err
+	}
+
+	return Digests{"sha256": h.Sum(nil)}.Verify(Digests{"sha256": d["sha256"]})
 }
 
-func (fp FileProvider) Write(u *url.URL) (WriteDestroyCloser, error) {
-	var (
-		flag = os.O_WRONLY | os.O_CREATE
-		mode = os.FileMode(0644)
-		q    = u.Query()
-	)
+// AtomicFile writes to a temporary file in the same directory as the destination,
+// which is renamed to the destination when committed.
+type AtomicFile struct {
+	*os.File
+	// Path is the destination of the file
+	Path string
 
-	if q.Get("append") != "" {
-		flag |= os.O_APPEND
-	} else {
-		flag |= os.O_TRUNC
-	}
+	noclobber bool
+	fsync     bool
+	renamed   bool
+}
 
-	if chmod := q.Get("chmod"); chmod != "" {
-		m, err := strconv.ParseUint(chmod, 8, 32)
+// Close closes the temporary file, flushing it to disk if fsync is enabled.
+func (f *AtomicFile) Close() error {
+	if f.fsync {
+		err := f.File.Sync()
 		if err != nil {
-			return nil, errors.Wrapf(err, "invalid file mode option %q", chmod)
+			return Catch(nil, err, f.File.Close()).AsError()
 		}
-		mode = os.FileMode(m)
-	}
-
-	path := fp.Target(u)
-	f, err := os.OpenFile(path, flag, mode)
-	if err != nil {
-		return nil, err
 	}
 
-	return &DestroyableFile{
-		Path: path,
-		File: f,
-	}, nil
+	return f.File.Close()
 }
 
-type S3PutObject struct {
-	s *session.Session
-	g *errgroup.Group
+// Commit renames the temporary file to the destination.
+// With noclobber, Commit fails if the destination already exists.
+func (f *AtomicFile) Commit() error {
+	var err error
+	if f.noclobber {
+		// Linking fails if the destination exists, unlike rename which replaces it.
+		err = os.Link(f.Name(), f.Path)
+		if err == nil {
+			err = os.Remove(f.Name())
+		}
+	} else {
+		err = os.Rename(f.Name(), f.Path)
+	}
+	if err != nil {
+		return err
+	}
 
-	key    string
-	bucket string
+	f.renamed = true
 
-	pr io.ReadCloser
-	pw io.WriteCloser
+	if f.fsync {
+		return syncDir(filepath.Dir(f.Path))
+	}
+	return nil
 }
 
-func (o *S3PutObject) Write(b []byte) (int, error) {
-	return o.pw.Write(b)
+// Destroy removes the temporary file, or the destination if the file has already been renamed.
+func (f *AtomicFile) Destroy() error {
+	if f.renamed {
+		return os.Remove(f.Path)
+	}
+	return os.Remove(f.Name())
 }
 
-func (o *S3PutObject) Close() error {
-	mu := new(MultiError)
-	mu.Catch(o.pw.Close)
-	mu.Append(o.g.Wait())
-	return mu.AsError()
+// Checksum verifies the content of the file by reading it back from disk.
+func (f *AtomicFile) Checksum(d Digests) error {
+	return (&DestroyableFile{Path: f.Path}).Checksum(d)
 }
 
-func (o *S3PutObject) Destroy() error {
-	_, err := s3.New(o.s).DeleteObject(&s3.DeleteObjectInput{
-		Key:    aws.String(o.key),
-		Bucket: aws.String(o.bucket),
-	})
-	return err
+// syncDir commits the entries of a directory to disk.
+func syncDir(path string) error {
+	d, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return Catch(nil, d.Sync(), d.Close()).AsError()
 }
 
-// Provides files from an S3-like HTTP interface
-type S3Provider struct {
-	Endpoint string
-	Region   string
+type FileProvider struct {
 }
 
-func (S3Provider) Schema() []string {
-	return []string{"s3", "s3+insecure"}
+func (FileProvider) Schema() []string {
+	return []string{"file"}
 }
 
-func (p S3Provider) Session(u *url.URL) (*session.Session, error) {
-	var disableSsl bool
-	if u.Scheme == "s3+insecure" {
-		disableSsl = true
-	}
-	return session.NewSession(&aws.Config{
-		Endpoint:   aws.String(p.Endpoint),
-		Region:     aws.String(p.Region),
-		DisableSSL: &disableSsl,
-	})
+func (FileProvider) Target(u *url.URL) string {
+	return filepath.Join(u.Host, u.Path)
 }
 
-func (p S3Provider) Read(u *url.URL) (io.ReadCloser, error) {
-	s, err := p.Session(u)
-	if err != nil {
-		return nil, err
-	}
-
-	o, err := s3.New(s).GetObject(&s3.GetObjectInput{
-		Bucket: aws.String(u.Host),
-		Key:    aws.String(u.Path),
-	})
-
-	if err != nil {
-		return nil, err
-	}
-
-	return o.Body, nil
+func (fp FileProvider) Read(u *url.URL) (io.ReadCloser, error) {
+	return os.OpenFile(fp.Target(u), os.O_RDONLY, os.FileMode(0644))
 }
 
-func (p S3Provider) uploadInput(u *url.URL, r io.Reader) *s3manager.UploadInput {
-	q := u.Query()
-	return &s3manager.UploadInput{
-		Bucket:      aws.String(u.Host),
-		Key:         aws.String(u.Path),
-		ACL:         aws.String(q.Get("acl")),
-		ContentType: aws.String(q.Get("type")),
-		Body:        r,
-	}
-}
+// Write creates a file at the path of the URL.
+// Unless appending, the file is written to a temporary file which is renamed into place when committed.
+func (fp FileProvider) Write(u *url.URL) (WriteDestroyCloser, error) {
+	var (
+		path      = fp.Target(u)
+		mode      = os.FileMode(0644)
+		q         = u.Query()
+		noclobber = queryFlag(q, "noclobber")
+	)
 
-func (p S3Provider) Write(u *url.URL) (WriteDestroyCloser, error) {
-	s, err := p.Session(u)
-	if err != nil {
-		return nil, err
+	if chmod := q.Get("chmod"); chmod != "" {
+		m, err := strconv.ParseUint(chmod, 8, 32)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid file mode option %q", chmod)
+		}
+		mode = os.FileMode(m)
+	} else if st, err := os.Stat(path); err == nil {
+		// Keep the permissions of a file being replaced
+		mode = st.Mode().Perm()
 	}
 
-	pr, pw := io.Pipe()
-
-	uploader := s3manager.NewUploader(s)
+	if queryFlag(q, "append") {
+		if noclobber {
+			return nil, errors.New("the append and noclobber options cannot be combined")
+		}
 
-	g, ctx := errgroup.WithContext(context.Background())
-	g.Go(func() error {
-		_, err := uploader.UploadWithContext(ctx, p.uploadInput(u, pr))
-		return Catch(nil, err, pr.Close()).AsError()
-	})
+		existed := true
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, mode)
+		if os.IsNotExist(err) {
+			existed = false
+			f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_APPEND, mode)
+		}
+		if err != nil {
+			return nil, err
+		}
 
-	return &S3PutObject{
-		s:      s,
-		g:      g,
-		key:    u.Path,
-		bucket: u.Host,
-		pr:     pr,
-		pw:     pw,
-	}, nil
-}
+		offset, err := f.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, Catch(nil, err, f.Close()).AsError()
+		}
 
-// HTTPProvider provides a source from an HTTP url
-type HTTPProvider struct {
-	Client *http.Client
-}
+		return &DestroyableFile{
+			Path:    path,
+			Offset:  offset,
+			Existed: existed,
+			File:    f,
+		}, nil
+	}
 
-func (p *HTTPProvider) Schema() []string {
-	return []string{"http", "https"}
-}
+	if noclobber {
+		if _, err := os.Lstat(path); err == nil {
+			return nil, errors.Errorf("file %q already exists", path)
+		}
+	}
 
-func (p *HTTPProvider) Read(u *url.URL) (io.ReadCloser, error) {
-	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("User-Agent", "fifo/0.1a")
-
-	resp, err := p.Client.Do(req)
+	err = f.Chmod(mode)
 	if err != nil {
-		return nil, err
+		return nil, Catch(nil, err, f.Close(), os.Remove(f.Name())).AsError()
 	}
 
-	return resp.Body, nil
+	return &AtomicFile{
+		File:      f,
+		Path:      path,
+		noclobber: noclobber,
+		fsync:     queryFlag(q, "fsync"),
+	}, nil
 }
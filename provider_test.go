@@ -0,0 +1,78 @@
+package fifo
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileProviderAppendDestroy(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing *string
+		commit   bool
+		want     *string
+	}{
+		{name: "destroy existing", existing: str("old\n"), want: str("old\n")},
+		{name: "destroy empty", existing: str(""), want: str("")},
+		{name: "destroy created"},
+		{name: "commit existing", existing: str("old\n"), commit: true, want: str("old\nnew\n")},
+		{name: "commit created", commit: true, want: str("new\n")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "fifo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			path := filepath.Join(dir, "log.txt")
+			if tt.existing != nil {
+				err = ioutil.WriteFile(path, []byte(*tt.existing), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			w, err := FileProvider{}.Write(&url.URL{Scheme: "file", Path: path, RawQuery: "append"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = w.Write([]byte("new\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = w.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.commit {
+				err = w.Commit()
+			} else {
+				err = w.Destroy()
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			b, err := ioutil.ReadFile(path)
+			switch {
+			case tt.want == nil && !os.IsNotExist(err):
+				t.Errorf("file exists with %q, want removed", b)
+			case tt.want != nil && err != nil:
+				t.Errorf("unable to read file: %v", err)
+			case tt.want != nil && string(b) != *tt.want:
+				t.Errorf("file contains %q, want %q", b, *tt.want)
+			}
+		})
+	}
+}
+
+func str(s string) *string {
+	return &s
+}
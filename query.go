@@ -0,0 +1,45 @@
+package fifo
+
+import (
+	"net/url"
+	"strings"
+)
+
+// queryFlag reports whether a boolean query option is enabled.
+// An option given without a value (such as `?agent`) is enabled,
+// as is any value other than `0`, `false`, `no` or `off`.
+func queryFlag(q url.Values, key string) bool {
+	v, ok := q[key]
+	if !ok {
+		return false
+	}
+	if len(v) == 0 {
+		return true
+	}
+
+	switch strings.ToLower(v[0]) {
+	case "0", "false", "no", "off":
+		return false
+	}
+	return true
+}
+
+// withoutQuery returns a copy of the URL with the given query options removed.
+// The URL is returned as-is if it does not contain any of the options.
+func withoutQuery(u *url.URL, keys ...string) *url.URL {
+	q := u.Query()
+	var found bool
+	for _, k := range keys {
+		if _, ok := q[k]; ok {
+			q.Del(k)
+			found = true
+		}
+	}
+	if !found {
+		return u
+	}
+
+	c := *u
+	c.RawQuery = q.Encode()
+	return &c
+}
@@ -0,0 +1,122 @@
+package fifo
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ByteRange is a range of bytes of a source given by the `?range` option of a URL,
+// such as `bytes=100-199`, `bytes=100-` to read from an offset, or `bytes=-100` to read the last 100 bytes.
+type ByteRange struct {
+	// Start is the offset of the first byte, or -1 to read the last Suffix bytes
+	Start int64
+	// End is the offset of the last byte, or -1 to read until the end of the source
+	End int64
+	// Suffix is the number of bytes to read from the end of the source if Start is -1
+	Suffix int64
+}
+
+// ParseByteRange parses a single range of bytes in the format of the HTTP Range header.
+func ParseByteRange(v string) (*ByteRange, error) {
+	spec := strings.TrimPrefix(v, "bytes=")
+	dash := strings.Index(spec, "-")
+	if dash < 0 || strings.Contains(spec, ",") {
+		return nil, errors.Errorf("invalid range %q", v)
+	}
+
+	first, last := spec[:dash], spec[dash+1:]
+	if first == "" {
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix < 1 {
+			return nil, errors.Errorf("invalid range %q", v)
+		}
+		return &ByteRange{Start: -1, End: -1, Suffix: suffix}, nil
+	}
+
+	r := &ByteRange{End: -1}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return nil, errors.Errorf("invalid range %q", v)
+	}
+	r.Start = start
+
+	if last != "" {
+		end, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return nil, errors.Errorf("invalid range %q", v)
+		}
+		r.End = end
+	}
+
+	return r, nil
+}
+
+// byteRange returns the `?range` option of a URL, or nil if the option is not given.
+func byteRange(q url.Values) (*ByteRange, error) {
+	v := q.Get("range")
+	if v == "" {
+		return nil, nil
+	}
+	return ParseByteRange(v)
+}
+
+// Header returns the value of a Range header requesting the range starting offset bytes into it.
+func (r *ByteRange) Header(offset int64) string {
+	switch {
+	case r.Start < 0:
+		// Only requested by the first request, after which the range is resolved
+		return fmt.Sprintf("bytes=-%d", r.Suffix)
+	case r.End < 0:
+		return fmt.Sprintf("bytes=%d-", r.Start+offset)
+	}
+	return fmt.Sprintf("bytes=%d-%d", r.Start+offset, r.End)
+}
+
+// Served validates the Content-Range of a response to the range requested starting offset bytes into it.
+// The range is resolved to the offsets served in the first response,
+// so that a range read from the end of the source is resumed at the same offsets.
+func (r *ByteRange) Served(offset int64, contentRange string) error {
+	var (
+		first, last int64
+		size        string
+	)
+	_, err := fmt.Sscanf(contentRange, "bytes %d-%d/%s", &first, &last, &size)
+	if err != nil {
+		return errors.Errorf("invalid Content-Range %q", contentRange)
+	}
+
+	total := int64(-1)
+	if size != "*" {
+		total, err = strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			return errors.Errorf("invalid Content-Range %q", contentRange)
+		}
+	}
+
+	start, end := r.Start, r.End
+	if start < 0 {
+		if total < 0 {
+			return errors.Errorf("server did not respond with the size of the source in Content-Range %q", contentRange)
+		}
+		start = total - r.Suffix
+		if start < 0 {
+			start = 0
+		}
+	}
+	switch {
+	case end < 0 && total < 0:
+		end = last
+	case end < 0 || (total >= 0 && end >= total):
+		end = total - 1
+	}
+
+	if first != start+offset || last != end {
+		return errors.Errorf("server responded with range %d-%d but %d-%d was requested", first, last, start+offset, end)
+	}
+
+	r.Start, r.End = start, end
+	return nil
+}
@@ -0,0 +1,101 @@
+package fifo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseByteRange(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    *ByteRange
+		wantErr bool
+	}{
+		{value: "bytes=100-199", want: &ByteRange{Start: 100, End: 199}},
+		{value: "100-199", want: &ByteRange{Start: 100, End: 199}},
+		{value: "bytes=0-0", want: &ByteRange{Start: 0, End: 0}},
+		{value: "bytes=100-", want: &ByteRange{Start: 100, End: -1}},
+		{value: "bytes=-100", want: &ByteRange{Start: -1, End: -1, Suffix: 100}},
+		{value: "bytes=-0", wantErr: true},
+		{value: "bytes=200-100", wantErr: true},
+		{value: "bytes=0-1,5-6", wantErr: true},
+		{value: "bytes=a-b", wantErr: true},
+		{value: "bytes=100", wantErr: true},
+		{value: "bytes=-", wantErr: true},
+		{value: "bytes=--1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseByteRange(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseByteRange(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseByteRange(%q) = %+v, want %+v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestByteRangeHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		r      ByteRange
+		offset int64
+		want   string
+	}{
+		{name: "closed", r: ByteRange{Start: 100, End: 199}, want: "bytes=100-199"},
+		{name: "closed resumed", r: ByteRange{Start: 100, End: 199}, offset: 50, want: "bytes=150-199"},
+		{name: "open-ended", r: ByteRange{Start: 100, End: -1}, want: "bytes=100-"},
+		{name: "open-ended resumed", r: ByteRange{Start: 100, End: -1}, offset: 50, want: "bytes=150-"},
+		{name: "suffix", r: ByteRange{Start: -1, End: -1, Suffix: 100}, want: "bytes=-100"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.r.Header(tt.offset); got != tt.want {
+				t.Errorf("Header(%d) = %q, want %q", tt.offset, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestByteRangeServed(t *testing.T) {
+	tests := []struct {
+		name         string
+		r            ByteRange
+		offset       int64
+		contentRange string
+		want         ByteRange
+		wantErr      bool
+	}{
+		{name: "closed", r: ByteRange{Start: 100, End: 199}, contentRange: "bytes 100-199/1000", want: ByteRange{Start: 100, End: 199}},
+		{name: "closed resumed", r: ByteRange{Start: 100, End: 199}, offset: 50, contentRange: "bytes 150-199/1000", want: ByteRange{Start: 100, End: 199}},
+		{name: "closed past the end", r: ByteRange{Start: 900, End: 1999}, contentRange: "bytes 900-999/1000", want: ByteRange{Start: 900, End: 999}},
+		{name: "closed unknown size", r: ByteRange{Start: 100, End: 199}, contentRange: "bytes 100-199/*", want: ByteRange{Start: 100, End: 199}},
+		{name: "open-ended", r: ByteRange{Start: 100, End: -1}, contentRange: "bytes 100-999/1000", want: ByteRange{Start: 100, End: 999}},
+		{name: "open-ended unknown size", r: ByteRange{Start: 100, End: -1}, contentRange: "bytes 100-999/*", want: ByteRange{Start: 100, End: 999}},
+		{name: "suffix", r: ByteRange{Start: -1, End: -1, Suffix: 100}, contentRange: "bytes 900-999/1000", want: ByteRange{Start: 900, End: 999, Suffix: 100}},
+		{name: "suffix larger than source", r: ByteRange{Start: -1, End: -1, Suffix: 2000}, contentRange: "bytes 0-999/1000", want: ByteRange{Start: 0, End: 999, Suffix: 2000}},
+		{name: "suffix unknown size", r: ByteRange{Start: -1, End: -1, Suffix: 100}, contentRange: "bytes 900-999/*", wantErr: true},
+		{name: "wrong start", r: ByteRange{Start: 100, End: 199}, contentRange: "bytes 0-199/1000", wantErr: true},
+		{name: "wrong end", r: ByteRange{Start: 100, End: 199}, contentRange: "bytes 100-149/1000", wantErr: true},
+		{name: "resumed from the start", r: ByteRange{Start: 100, End: -1}, offset: 50, contentRange: "bytes 100-999/1000", wantErr: true},
+		{name: "invalid", r: ByteRange{Start: 100, End: 199}, contentRange: "100-199", wantErr: true},
+		{name: "invalid size", r: ByteRange{Start: 100, End: 199}, contentRange: "bytes 100-199/abc", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.r
+			err := r.Served(tt.offset, tt.contentRange)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Served(%d, %q) error = %v, wantErr %v", tt.offset, tt.contentRange, err, tt.wantErr)
+			}
+			if !tt.wantErr && r != tt.want {
+				t.Errorf("Served(%d, %q) resolved %+v, want %+v", tt.offset, tt.contentRange, r, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package fifo
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"io"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy describes how a source stream is resumed after a transient error.
+type RetryPolicy struct {
+	// Retries is the number of consecutive attempts made to resume a stream. Zero disables resuming.
+	Retries int
+	// Backoff is the delay before the first attempt, doubling for each subsequent attempt.
+	Backoff time.Duration
+}
+
+// Override returns a copy of the policy using the `?retries` and `?retry_backoff` options of a URL if given.
+func (p RetryPolicy) Override(q url.Values) (RetryPolicy, error) {
+	if v := q.Get("retries"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return p, errors.Errorf("invalid retries option %q", v)
+		}
+		p.Retries = n
+	}
+
+	if v := q.Get("retry_backoff"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return p, errors.Errorf("invalid retry_backoff option %q", v)
+		}
+		p.Backoff = d
+	}
+
+	return p, nil
+}
+
+// A RangeOpener opens an object for reading starting at the given byte offset.
+// The returned version identifies the content of the object, such as an ETag or Last-Modified date.
+// An empty version indicates that the object cannot be safely resumed.
+type RangeOpener func(ctx context.Context, offset int64) (body io.ReadCloser, version string, err error)
+
+// ResumableReader reads an object from a RangeOpener.
+// When reading fails with an error other than io.EOF, the object is reopened starting from the last byte read,
+// so long as the version of the object has not changed.
+type ResumableReader struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	open    RangeOpener
+	policy  RetryPolicy
+	version string
+	offset  int64
+	body    io.ReadCloser
+}
+
+// NewResumableReader opens an object from the beginning using the given opener.
+func NewResumableReader(policy RetryPolicy, open RangeOpener) (*ResumableReader, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	body, version, err := open(ctx, 0)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return &ResumableReader{
+		ctx:     ctx,
+		cancel:  cancel,
+		open:    open,
+		policy:  policy,
+		version: version,
+		body:    body,
+	}, nil
+}
+
+// resume reopens the object at the current offset after a read error.
+func (r *ResumableReader) resume(cause error) error {
+	if r.version == "" || r.policy.Retries < 1 {
+		return cause
+	}
+
+	_ = r.body.Close()
+	r.body = nil
+
+	backoff := r.policy.Backoff
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-r.ctx.Done():
+			return cause
+		case <-time.After(backoff):
+		}
+
+		body, version, err := r.open(r.ctx, r.offset)
+		if err == nil && version != r.version {
+			err = errors.Errorf("object changed from version %s to %s", r.version, version)
+			_ = body.Close()
+			return errors.Wrapf(err, "unable to resume reading at offset %d", r.offset)
+		}
+		if err == nil {
+			r.body = body
+			return nil
+		}
+
+		if attempt >= r.policy.Retries {
+			return errors.Wrapf(cause, "unable to resume reading at offset %d after %d attempts (%v)", r.offset, attempt, err)
+		}
+		backoff *= 2
+	}
+}
+
+func (r *ResumableReader) Read(b []byte) (int, error) {
+	for {
+		if r.body == nil {
+			return 0, io.ErrClosedPipe
+		}
+
+		n, err := r.body.Read(b)
+		r.offset += int64(n)
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+
+		err = r.resume(err)
+		if err != nil || n > 0 {
+			return n, err
+		}
+	}
+}
+
+func (r *ResumableReader) Close() error {
+	r.cancel()
+	if r.body == nil {
+		return nil
+	}
+	return r.body.Close()
+}
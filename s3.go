@@ -0,0 +1,827 @@
+package fifo
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/pkg/errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// s3ETag computes the expected ETag of an object uploaded in parts of a fixed size.
+// Objects smaller than the part size are uploaded in a single request, where the ETag is the MD5 digest of the object.
+// Otherwise the ETag is the MD5 digest of the concatenated digests of each part, suffixed with the number of parts.
+type s3ETag struct {
+	partSize int64
+	size     int64
+	part     hash.Hash
+	sums     []byte
+}
+
+func newS3ETag(partSize int64) *s3ETag {
+	return &s3ETag{
+		partSize: partSize,
+		part:     md5.New(),
+	}
+}
+
+func (e *s3ETag) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		c := e.partSize - e.size%e.partSize
+		if c > int64(len(b)) {
+			c = int64(len(b))
+		}
+
+		_, _ = e.part.Write(b[:c])
+		e.size += c
+		b = b[c:]
+
+		if e.size%e.partSize == 0 {
+			e.sums = e.part.Sum(e.sums)
+			e.part.Reset()
+		}
+	}
+	return n, nil
+}
+
+func (e *s3ETag) String() string {
+	if e.size < e.partSize {
+		return hex.EncodeToString(e.part.Sum(nil))
+	}
+
+	sums := e.sums
+	if e.size%e.partSize != 0 {
+		sums = e.part.Sum(sums)
+	}
+
+	return fmt.Sprintf("%x-%d", md5.Sum(sums), len(sums)/md5.Size)
+}
+
+// S3PutObject uploads an object to S3 in parts as it is written.
+// An object smaller than a single part is uploaded in a single request once committed.
+// Otherwise a multipart upload is created when the first part is full, which is completed once committed.
+// Up to concurrency parts are uploaded at the same time while the next part is written.
+type S3PutObject struct {
+	svc   *s3.S3
+	input *s3.CreateMultipartUploadInput
+	etag  *s3ETag
+
+	partSize int64
+	buf      []byte
+	uploadID *string
+
+	// ctx is cancelled to stop uploading parts once an upload has failed or is destroyed
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// sem limits the number of parts being uploaded, whose buffers are reused through free
+	sem     chan struct{}
+	free    chan []byte
+	uploads sync.WaitGroup
+
+	mu        sync.Mutex
+	parts     []*s3.CompletedPart
+	err       error
+	committed bool
+}
+
+// failed returns the first error uploading a part.
+func (o *S3PutObject) failed() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.err
+}
+
+// uploadPart starts uploading the buffered data as the next part of the multipart upload,
+// creating the multipart upload if this is the first part.
+func (o *S3PutObject) uploadPart() error {
+	if err := o.failed(); err != nil {
+		return err
+	}
+
+	if o.uploadID == nil {
+		upload, err := o.svc.CreateMultipartUpload(o.input)
+		if err != nil {
+			return err
+		}
+		o.uploadID = upload.UploadId
+	}
+
+	o.mu.Lock()
+	if len(o.parts) == s3manager.MaxUploadParts {
+		o.mu.Unlock()
+		return errors.Errorf("object exceeds the maximum of %d parts of %d bytes, use a larger ?part_size or set ?size", s3manager.MaxUploadParts, o.partSize)
+	}
+	index := len(o.parts)
+	o.parts = append(o.parts, nil)
+	o.mu.Unlock()
+
+	o.sem <- struct{}{}
+	o.uploads.Add(1)
+
+	buf := o.buf
+	o.buf = nil
+
+	go func() {
+		defer o.uploads.Done()
+
+		number := aws.Int64(int64(index + 1))
+		part, err := o.svc.UploadPartWithContext(o.ctx, &s3.UploadPartInput{
+			Bucket:     o.input.Bucket,
+			Key:        o.input.Key,
+			UploadId:   o.uploadID,
+			PartNumber: number,
+			Body:       bytes.NewReader(buf),
+
+			SSECustomerAlgorithm: o.input.SSECustomerAlgorithm,
+			SSECustomerKey:       o.input.SSECustomerKey,
+		})
+
+		o.mu.Lock()
+		if err != nil && o.err == nil {
+			o.err = err
+			o.cancel()
+		}
+		if err == nil {
+			o.parts[index] = &s3.CompletedPart{
+				ETag:       part.ETag,
+				PartNumber: number,
+			}
+		}
+		o.mu.Unlock()
+
+		select {
+		case o.free <- buf[:0]:
+		default:
+		}
+		<-o.sem
+	}()
+
+	return nil
+}
+
+func (o *S3PutObject) Write(b []byte) (n int, err error) {
+	for len(b) > 0 {
+		if o.buf == nil {
+			select {
+			case o.buf = <-o.free:
+			default:
+				o.buf = make([]byte, 0, o.partSize)
+			}
+		}
+
+		c := copy(o.buf[len(o.buf):cap(o.buf)], b)
+		o.buf = o.buf[:len(o.buf)+c]
+		_, _ = o.etag.Write(b[:c])
+		b = b[c:]
+		n += c
+
+		if len(o.buf) == cap(o.buf) {
+			err = o.uploadPart()
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Close uploads the remaining data as the last part of a multipart upload and waits for all parts to be uploaded.
+// The data of an object smaller than a single part remains buffered until committed.
+func (o *S3PutObject) Close() error {
+	if o.uploadID != nil && len(o.buf) > 0 {
+		err := o.uploadPart()
+		if err != nil {
+			o.uploads.Wait()
+			return err
+		}
+	}
+
+	o.uploads.Wait()
+	return o.failed()
+}
+
+// Commit completes the multipart upload, or uploads an object smaller than a single part.
+func (o *S3PutObject) Commit() error {
+	var err error
+	if o.uploadID == nil {
+		_, err = o.svc.PutObject(&s3.PutObjectInput{
+			Bucket:               o.input.Bucket,
+			Key:                  o.input.Key,
+			ACL:                  o.input.ACL,
+			ContentType:          o.input.ContentType,
+			CacheControl:         o.input.CacheControl,
+			ContentEncoding:      o.input.ContentEncoding,
+			StorageClass:         o.input.StorageClass,
+			ServerSideEncryption: o.input.ServerSideEncryption,
+			SSEKMSKeyId:          o.input.SSEKMSKeyId,
+			SSECustomerAlgorithm: o.input.SSECustomerAlgorithm,
+			SSECustomerKey:       o.input.SSECustomerKey,
+			Metadata:             o.input.Metadata,
+			Tagging:              o.input.Tagging,
+			Body:                 bytes.NewReader(o.buf),
+		})
+	} else {
+		_, err = o.svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+			Bucket:          o.input.Bucket,
+			Key:             o.input.Key,
+			UploadId:        o.uploadID,
+			MultipartUpload: &s3.CompletedMultipartUpload{Parts: o.parts},
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	o.committed = true
+	return nil
+}
+
+// Checksum verifies the ETag of the uploaded object against the content written.
+func (o *S3PutObject) Checksum(Digests) error {
+	head, err := o.svc.HeadObject(&s3.HeadObjectInput{
+		Key:                  o.input.Key,
+		Bucket:               o.input.Bucket,
+		SSECustomerAlgorithm: o.input.SSECustomerAlgorithm,
+		SSECustomerKey:       o.input.SSECustomerKey,
+	})
+	if err != nil {
+		return err
+	}
+
+	if head.SSECustomerAlgorithm != nil || aws.StringValue(head.ServerSideEncryption) == s3.ServerSideEncryptionAwsKms {
+		return errors.New("the ETag of an object encrypted using SSE-C or SSE-KMS cannot be verified")
+	}
+
+	etag := strings.Trim(aws.StringValue(head.ETag), `"`)
+	if expected := o.etag.String(); etag != expected {
+		return errors.Errorf("ETag mismatch: expected %s but got %s", expected, etag)
+	}
+	return nil
+}
+
+// Destroy aborts an uncommitted multipart upload, or deletes the object if it has been committed.
+// Parts still being uploaded are cancelled so that no parts remain once the multipart upload is aborted.
+func (o *S3PutObject) Destroy() error {
+	o.cancel()
+	o.uploads.Wait()
+
+	if o.committed {
+		_, err := o.svc.DeleteObject(&s3.DeleteObjectInput{
+			Key:    o.input.Key,
+			Bucket: o.input.Bucket,
+		})
+		return err
+	}
+
+	if o.uploadID == nil {
+		return nil
+	}
+
+	_, err := o.svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   o.input.Bucket,
+		Key:      o.input.Key,
+		UploadId: o.uploadID,
+	})
+	return err
+}
+
+// Provides files from an S3-like HTTP interface
+type S3Provider struct {
+	Endpoint string
+	Region   string
+	// Profile is the shared configuration profile used unless overridden by the URL.
+	Profile string
+	// Credentials are used instead of the default credential chain, unless the URL selects a profile.
+	Credentials *credentials.Credentials
+	// RoleARN is an IAM role assumed using the credentials unless overridden by the URL.
+	RoleARN string
+	// ExternalID is passed when assuming a role unless overridden by the URL.
+	ExternalID string
+	// PathStyle addresses buckets as part of the path of requests rather than the hostname,
+	// such as for S3-compatible stores like MinIO, unless overridden by the URL.
+	PathStyle bool
+	// PartSize is the size of each part of an uploaded object unless overridden by the URL. Defaults to 5 MiB
+	PartSize int64
+	// Concurrency is the number of parts uploaded at the same time unless overridden by the URL. Defaults to 5
+	Concurrency int
+	// Retry is used to resume reading an object after a transient error unless overridden by the URL.
+	Retry RetryPolicy
+
+	mu sync.Mutex
+	// sessions are the sessions of objects read in parts, created once for all parts of each URL
+	sessions map[string]*session.Session
+}
+
+func (*S3Provider) Schema() []string {
+	return []string{"s3", "s3+insecure"}
+}
+
+// s3Key returns the key of the object named by the path of a URL.
+// The key is the decoded path of the URL, so that `%2F` is a slash, `%20` a space and `%3F` a question mark,
+// while a `+` is kept as a plus sign. The slash separating the bucket from the key is removed
+// unless the `?preserve_leading_slash` option is set, which names an object whose key starts with a slash.
+func s3Key(u *url.URL) string {
+	if queryFlag(u.Query(), "preserve_leading_slash") {
+		return u.Path
+	}
+	return strings.TrimPrefix(u.Path, "/")
+}
+
+// Session creates a session for a URL.
+// Credentials are taken from the `?profile` of the URL, or otherwise the provider,
+// which are used to assume the `?role_arn` of the URL or provider if set.
+func (p *S3Provider) Session(u *url.URL) (*session.Session, error) {
+	var (
+		q          = u.Query()
+		profile    = p.Profile
+		creds      = p.Credentials
+		roleARN    = p.RoleARN
+		externalID = p.ExternalID
+		disableSsl bool
+	)
+
+	if v := q.Get("profile"); v != "" {
+		profile = v
+		creds = nil
+	}
+	if v := q.Get("role_arn"); v != "" {
+		roleARN = v
+		externalID = ""
+	}
+	if v := q.Get("external_id"); v != "" {
+		externalID = v
+	}
+	if externalID != "" && roleARN == "" {
+		return nil, errors.New("the external_id option requires a role to assume")
+	}
+
+	if u.Scheme == "s3+insecure" {
+		disableSsl = true
+	}
+
+	// Static credentials of a selected profile take precedence over credentials in the environment,
+	// otherwise the profile is resolved by the session such as a profile which assumes a role.
+	if profile != "" && creds == nil {
+		shared := credentials.NewSharedCredentials("", profile)
+		if _, err := shared.Get(); err == nil {
+			creds = shared
+		}
+	}
+
+	// The endpoint only applies to S3, so the base session is used for STS when assuming a role
+	s, err := session.NewSessionWithOptions(session.Options{
+		Config: aws.Config{
+			Region:      s3String(p.Region),
+			Credentials: creds,
+		},
+		Profile:           profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pathStyle := p.PathStyle
+	if _, ok := q["path_style"]; ok {
+		pathStyle = queryFlag(q, "path_style")
+	}
+
+	config := &aws.Config{
+		Endpoint:         s3String(p.Endpoint),
+		DisableSSL:       &disableSsl,
+		S3ForcePathStyle: &pathStyle,
+		// Keys are sent as-is, otherwise repeated slashes in a key are removed
+		DisableRestProtocolURICleaning: aws.Bool(true),
+	}
+	if roleARN != "" {
+		config.Credentials = stscreds.NewCredentials(s, roleARN, func(r *stscreds.AssumeRoleProvider) {
+			r.ExternalID = s3String(externalID)
+		})
+	}
+
+	return s.Copy(config), nil
+}
+
+func (p *S3Provider) Read(u *url.URL) (io.ReadCloser, error) {
+	policy, err := p.Retry.Override(u.Query())
+	if err != nil {
+		return nil, err
+	}
+
+	algorithm, key, err := s3CustomerKey(u.Query())
+	if err != nil {
+		return nil, err
+	}
+
+	rng, err := byteRange(u.Query())
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := p.Session(u)
+	if err != nil {
+		return nil, err
+	}
+
+	svc := s3.New(s)
+	return NewResumableReader(policy, func(ctx context.Context, offset int64) (io.ReadCloser, string, error) {
+		input := &s3.GetObjectInput{
+			Bucket:               aws.String(u.Host),
+			Key:                  aws.String(s3Key(u)),
+			VersionId:            s3String(u.Query().Get("version_id")),
+			SSECustomerAlgorithm: algorithm,
+			SSECustomerKey:       key,
+		}
+		switch {
+		case rng != nil:
+			input.Range = aws.String(rng.Header(offset))
+		case offset > 0:
+			input.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+		}
+
+		o, err := svc.GetObjectWithContext(ctx, input)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if rng != nil {
+			err = rng.Served(offset, aws.StringValue(o.ContentRange))
+			if err != nil {
+				_ = o.Body.Close()
+				return nil, "", err
+			}
+		}
+
+		return o.Body, aws.StringValue(o.ETag), nil
+	})
+}
+
+// Stat returns the size and ETag of an object.
+func (p *S3Provider) Stat(u *url.URL) (int64, string, error) {
+	algorithm, key, err := s3CustomerKey(u.Query())
+	if err != nil {
+		return 0, "", err
+	}
+
+	s, err := p.rangeSession(u)
+	if err != nil {
+		return 0, "", err
+	}
+
+	head, err := s3.New(s).HeadObject(&s3.HeadObjectInput{
+		Bucket:               aws.String(u.Host),
+		Key:                  aws.String(s3Key(u)),
+		VersionId:            s3String(u.Query().Get("version_id")),
+		SSECustomerAlgorithm: algorithm,
+		SSECustomerKey:       key,
+	})
+	if err != nil {
+		return 0, "", err
+	}
+
+	return aws.Int64Value(head.ContentLength), aws.StringValue(head.ETag), nil
+}
+
+// rangeSession returns the session used to read the parts of an object,
+// so that credentials such as an assumed role are only requested once for all parts.
+func (p *S3Provider) rangeSession(u *url.URL) (*session.Session, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if s, ok := p.sessions[u.String()]; ok {
+		return s, nil
+	}
+
+	s, err := p.Session(u)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.sessions == nil {
+		p.sessions = make(map[string]*session.Session)
+	}
+	p.sessions[u.String()] = s
+	return s, nil
+}
+
+// ReadRange reads part of an object, conditional on the ETag of the object matching the given version.
+func (p *S3Provider) ReadRange(u *url.URL, version string, offset, length int64) (io.ReadCloser, error) {
+	algorithm, key, err := s3CustomerKey(u.Query())
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := p.rangeSession(u)
+	if err != nil {
+		return nil, err
+	}
+
+	o, err := s3.New(s).GetObject(&s3.GetObjectInput{
+		Bucket:               aws.String(u.Host),
+		Key:                  aws.String(s3Key(u)),
+		Range:                aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+		IfMatch:              aws.String(version),
+		VersionId:            s3String(u.Query().Get("version_id")),
+		SSECustomerAlgorithm: algorithm,
+		SSECustomerKey:       key,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return o.Body, nil
+}
+
+// s3String returns a pointer to a string, or nil if the string is empty.
+func s3String(v string) *string {
+	if v == "" {
+		return nil
+	}
+	return aws.String(v)
+}
+
+// s3StorageClasses are the supported storage classes of created objects.
+var s3StorageClasses = map[string]bool{
+	s3.StorageClassStandard:           true,
+	s3.StorageClassReducedRedundancy:  true,
+	s3.StorageClassStandardIa:         true,
+	s3.StorageClassOnezoneIa:          true,
+	s3.StorageClassIntelligentTiering: true,
+	s3.StorageClassGlacier:            true,
+	"GLACIER_IR":                      true,
+	s3.StorageClassDeepArchive:        true,
+	"OUTPOSTS":                        true,
+}
+
+// s3CannedACLs are the canned ACLs which can be set on created objects.
+var s3CannedACLs = map[string]bool{
+	s3.ObjectCannedACLPrivate:                true,
+	s3.ObjectCannedACLPublicRead:             true,
+	s3.ObjectCannedACLPublicReadWrite:        true,
+	s3.ObjectCannedACLAuthenticatedRead:      true,
+	s3.ObjectCannedACLAwsExecRead:            true,
+	s3.ObjectCannedACLBucketOwnerRead:        true,
+	s3.ObjectCannedACLBucketOwnerFullControl: true,
+}
+
+// s3CustomerKey reads the customer provided encryption key (SSE-C) from the file given by `?sse_customer_key_file`.
+// The file contains either the raw 256-bit key or the key encoded as base64.
+func s3CustomerKey(q url.Values) (algorithm, key *string, err error) {
+	path := q.Get("sse_customer_key_file")
+	if path == "" {
+		return nil, nil, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to read SSE-C key")
+	}
+
+	if len(b) != 32 {
+		b, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+		if err != nil || len(b) != 32 {
+			return nil, nil, errors.Errorf("SSE-C key file %q must contain a 256-bit key, optionally encoded as base64", path)
+		}
+	}
+
+	return aws.String("AES256"), aws.String(string(b)), nil
+}
+
+// s3Prefixed returns the options of a URL with a prefix, such as `?meta.owner=...`, keyed by the rest of the name.
+func s3Prefixed(q url.Values, prefix string) map[string]string {
+	m := make(map[string]string)
+	for k := range q {
+		if strings.HasPrefix(k, prefix) {
+			m[strings.TrimPrefix(k, prefix)] = q.Get(k)
+		}
+	}
+	return m
+}
+
+// uploadInput creates the parameters of the upload of an object from the options of a URL.
+// The options are validated so that an invalid option fails before the upload starts.
+func (p *S3Provider) uploadInput(u *url.URL) (*s3.CreateMultipartUploadInput, error) {
+	q := u.Query()
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:          aws.String(u.Host),
+		Key:             aws.String(s3Key(u)),
+		ContentType:     s3String(q.Get("type")),
+		CacheControl:    s3String(q.Get("cache_control")),
+		ContentEncoding: s3String(q.Get("content_encoding")),
+	}
+
+	if v := q.Get("acl"); v != "" {
+		if !s3CannedACLs[v] {
+			return nil, errors.Errorf("invalid acl option %q", v)
+		}
+		input.ACL = aws.String(v)
+	}
+
+	if v := q.Get("storage_class"); v != "" {
+		if !s3StorageClasses[v] {
+			return nil, errors.Errorf("invalid storage_class option %q", v)
+		}
+		input.StorageClass = aws.String(v)
+	}
+
+	switch v := q.Get("sse"); v {
+	case "":
+	case s3.ServerSideEncryptionAes256, s3.ServerSideEncryptionAwsKms:
+		input.ServerSideEncryption = aws.String(v)
+	default:
+		return nil, errors.Errorf("invalid sse option %q", v)
+	}
+
+	if v := q.Get("kms_key_id"); v != "" {
+		if aws.StringValue(input.ServerSideEncryption) != s3.ServerSideEncryptionAwsKms {
+			return nil, errors.New("the kms_key_id option requires sse=aws:kms")
+		}
+		input.SSEKMSKeyId = aws.String(v)
+	}
+
+	algorithm, key, err := s3CustomerKey(q)
+	if err != nil {
+		return nil, err
+	}
+	if key != nil && input.ServerSideEncryption != nil {
+		return nil, errors.New("the sse and sse_customer_key_file options cannot be combined")
+	}
+	input.SSECustomerAlgorithm = algorithm
+	input.SSECustomerKey = key
+
+	if meta := s3Prefixed(q, "meta."); len(meta) > 0 {
+		input.Metadata = make(map[string]*string, len(meta))
+		for k, v := range meta {
+			if k == "" {
+				return nil, errors.New("metadata option must be named, such as meta.owner")
+			}
+			input.Metadata[k] = aws.String(v)
+		}
+	}
+
+	if tags := s3Prefixed(q, "tag."); len(tags) > 0 {
+		if len(tags) > 10 {
+			return nil, errors.Errorf("an object can have at most 10 tags but got %d", len(tags))
+		}
+
+		tagging := make(url.Values)
+		for k, v := range tags {
+			if k == "" || len(k) > 128 || len(v) > 256 {
+				return nil, errors.Errorf("invalid tag option %q", "tag."+k)
+			}
+			tagging.Set(k, v)
+		}
+		input.Tagging = aws.String(tagging.Encode())
+	}
+
+	return input, nil
+}
+
+// s3MaxPartSize is the maximum size of a part of a multipart upload.
+const s3MaxPartSize = 5 << 30
+
+// uploadParts returns the size of each part and the number of parts uploaded at the same time from the options of a URL.
+// If the expected size of the object is given by `?size` then the part size is increased
+// so that the object can be uploaded within the maximum number of parts.
+func (p *S3Provider) uploadParts(q url.Values) (partSize int64, concurrency int, err error) {
+	partSize = p.PartSize
+	if partSize == 0 {
+		partSize = s3manager.DefaultUploadPartSize
+	}
+	if v := q.Get("part_size"); v != "" {
+		partSize, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, 0, errors.Errorf("invalid part_size option %q", v)
+		}
+	}
+	if partSize < s3manager.MinUploadPartSize || partSize > s3MaxPartSize {
+		return 0, 0, errors.Errorf("part size must be between %d and %d bytes but got %d", s3manager.MinUploadPartSize, int64(s3MaxPartSize), partSize)
+	}
+
+	if v := q.Get("size"); v != "" {
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || size < 0 {
+			return 0, 0, errors.Errorf("invalid size option %q", v)
+		}
+
+		// Round up to a whole MiB
+		if minimum := (size + s3manager.MaxUploadParts - 1) / s3manager.MaxUploadParts; minimum > partSize {
+			partSize = (minimum + 1<<20 - 1) &^ (1<<20 - 1)
+		}
+		if partSize > s3MaxPartSize {
+			return 0, 0, errors.Errorf("an object of %d bytes exceeds the maximum size of a multipart upload", size)
+		}
+	}
+
+	concurrency = p.Concurrency
+	if concurrency == 0 {
+		concurrency = 5
+	}
+	if v := q.Get("concurrency"); v != "" {
+		concurrency, err = strconv.Atoi(v)
+		if err != nil || concurrency < 1 {
+			return 0, 0, errors.Errorf("invalid concurrency option %q", v)
+		}
+	}
+
+	return partSize, concurrency, nil
+}
+
+func (p *S3Provider) Write(u *url.URL) (WriteDestroyCloser, error) {
+	input, err := p.uploadInput(u)
+	if err != nil {
+		return nil, err
+	}
+
+	partSize, concurrency, err := p.uploadParts(u.Query())
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := p.Session(u)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &S3PutObject{
+		ctx:      ctx,
+		cancel:   cancel,
+		svc:      s3.New(s),
+		input:    input,
+		etag:     newS3ETag(partSize),
+		partSize: partSize,
+		sem:      make(chan struct{}, concurrency),
+		free:     make(chan []byte, concurrency),
+	}, nil
+}
+
+// S3Upload is an incomplete multipart upload of an object.
+type S3Upload struct {
+	Key       string
+	UploadID  string
+	Initiated time.Time
+}
+
+// ListUploads lists the incomplete multipart uploads in the bucket of a URL initiated before a given time,
+// of objects whose key starts with the key of the URL, such as `backups/` for `s3://bucket/backups/`.
+func (p *S3Provider) ListUploads(u *url.URL, before time.Time) ([]S3Upload, error) {
+	s, err := p.Session(u)
+	if err != nil {
+		return nil, err
+	}
+
+	var uploads []S3Upload
+	err = s3.New(s).ListMultipartUploadsPages(&s3.ListMultipartUploadsInput{
+		Bucket: aws.String(u.Host),
+		Prefix: s3String(s3Key(u)),
+	}, func(page *s3.ListMultipartUploadsOutput, last bool) bool {
+		for _, upload := range page.Uploads {
+			initiated := aws.TimeValue(upload.Initiated)
+			if !initiated.Before(before) {
+				continue
+			}
+			uploads = append(uploads, S3Upload{
+				Key:       aws.StringValue(upload.Key),
+				UploadID:  aws.StringValue(upload.UploadId),
+				Initiated: initiated,
+			})
+		}
+		return true
+	})
+	return uploads, err
+}
+
+// AbortUpload aborts an incomplete multipart upload in the bucket of a URL, removing its uploaded parts.
+func (p *S3Provider) AbortUpload(u *url.URL, upload S3Upload) error {
+	s, err := p.Session(u)
+	if err != nil {
+		return err
+	}
+
+	_, err = s3.New(s).AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(u.Host),
+		Key:      aws.String(upload.Key),
+		UploadId: aws.String(upload.UploadID),
+	})
+	return err
+}
@@ -0,0 +1,298 @@
+package fifo
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestS3UploadParts(t *testing.T) {
+	const mib = 1 << 20
+
+	tests := []struct {
+		name            string
+		partSize        int64
+		concurrency     int
+		query           string
+		wantPartSize    int64
+		wantConcurrency int
+		wantErr         bool
+	}{
+		{name: "defaults", wantPartSize: 5 * mib, wantConcurrency: 5},
+		{name: "provider", partSize: 8 * mib, concurrency: 2, wantPartSize: 8 * mib, wantConcurrency: 2},
+		{name: "options", partSize: 8 * mib, concurrency: 2, query: "part_size=16777216&concurrency=4", wantPartSize: 16 * mib, wantConcurrency: 4},
+		{name: "part size too small", query: "part_size=1024", wantErr: true},
+		{name: "part size too large", query: "part_size=5368709121", wantErr: true},
+		{name: "invalid part size", query: "part_size=5MiB", wantErr: true},
+		{name: "invalid concurrency", query: "concurrency=0", wantErr: true},
+		{name: "small size", query: "size=1024", wantPartSize: 5 * mib, wantConcurrency: 5},
+		{name: "size within parts", query: "size=52428800000", wantPartSize: 5 * mib, wantConcurrency: 5},
+		{name: "size rounded to MiB", query: "size=100000000000", wantPartSize: 10 * mib, wantConcurrency: 5},
+		{name: "size larger than part size option", query: "part_size=16777216&size=10", wantPartSize: 16 * mib, wantConcurrency: 5},
+		{name: "size too large", query: "size=53687091200001", wantErr: true},
+		{name: "invalid size", query: "size=-1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			p := &S3Provider{PartSize: tt.partSize, Concurrency: tt.concurrency}
+			partSize, concurrency, err := p.uploadParts(q)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("uploadParts(%q) error = %v, wantErr %v", tt.query, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if partSize != tt.wantPartSize || concurrency != tt.wantConcurrency {
+				t.Errorf("uploadParts(%q) = %d, %d, want %d, %d", tt.query, partSize, concurrency, tt.wantPartSize, tt.wantConcurrency)
+			}
+		})
+	}
+}
+
+// multipartETag computes the ETag of an object uploaded in parts of partSize.
+func multipartETag(data []byte, partSize int) string {
+	var sums []byte
+	for len(data) > 0 {
+		n := partSize
+		if n > len(data) {
+			n = len(data)
+		}
+		sum := md5.Sum(data[:n])
+		sums = append(sums, sum[:]...)
+		data = data[n:]
+	}
+	return fmt.Sprintf("%x-%d", md5.Sum(sums), len(sums)/md5.Size)
+}
+
+func TestS3ETag(t *testing.T) {
+	const partSize = 4
+
+	tests := []struct {
+		name   string
+		data   []byte
+		writes int
+		want   string
+	}{
+		{name: "empty", data: nil, writes: 1, want: fmt.Sprintf("%x", md5.Sum(nil))},
+		{name: "smaller than a part", data: []byte("abc"), writes: 1, want: fmt.Sprintf("%x", md5.Sum([]byte("abc")))},
+		{name: "one part", data: []byte("abcd"), writes: 1, want: multipartETag([]byte("abcd"), partSize)},
+		{name: "whole parts", data: []byte("abcdefgh"), writes: 1, want: multipartETag([]byte("abcdefgh"), partSize)},
+		{name: "short last part", data: []byte("abcdefghij"), writes: 1, want: multipartETag([]byte("abcdefghij"), partSize)},
+		{name: "short last part in small writes", data: []byte("abcdefghij"), writes: 10, want: multipartETag([]byte("abcdefghij"), partSize)},
+		{name: "writes across parts", data: bytes.Repeat([]byte("xyz"), 7), writes: 3, want: multipartETag(bytes.Repeat([]byte("xyz"), 7), partSize)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newS3ETag(partSize)
+			data, size := tt.data, (len(tt.data)+tt.writes-1)/tt.writes
+			for len(data) > size {
+				_, _ = e.Write(data[:size])
+				data = data[size:]
+			}
+			_, _ = e.Write(data)
+
+			if got := e.String(); got != tt.want {
+				t.Errorf("ETag = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestS3ETagSuffix(t *testing.T) {
+	tests := []struct {
+		size int
+		want string
+	}{
+		{size: 3, want: ""},
+		{size: 4, want: "-1"},
+		{size: 5, want: "-2"},
+		{size: 8, want: "-2"},
+		{size: 9, want: "-3"},
+	}
+
+	for _, tt := range tests {
+		e := newS3ETag(4)
+		_, _ = e.Write(make([]byte, tt.size))
+
+		got := e.String()
+		if suffix := got[2*md5.Size:]; suffix != tt.want {
+			t.Errorf("ETag of %d bytes = %s, want suffix %q", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestS3ListUploadsPrefix(t *testing.T) {
+	var prefix []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix = r.URL.Query()["prefix"]
+		_, _ = fmt.Fprint(w, `<ListMultipartUploadsResult><Bucket>bucket</Bucket></ListMultipartUploadsResult>`)
+	}))
+	defer ts.Close()
+
+	p := &S3Provider{
+		Endpoint:    ts.URL,
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		PathStyle:   true,
+	}
+
+	tests := []struct {
+		url  string
+		want []string
+	}{
+		{url: "s3+insecure://bucket", want: nil},
+		{url: "s3+insecure://bucket/", want: nil},
+		{url: "s3+insecure://bucket/backups/", want: []string{"backups/"}},
+		{url: "s3+insecure://bucket/backups/2020-", want: []string{"backups/2020-"}},
+		{url: "s3+insecure://bucket//backups/", want: []string{"/backups/"}},
+		{url: "s3+insecure://bucket/backups/?preserve_leading_slash", want: []string{"/backups/"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			prefix = nil
+			_, err = p.ListUploads(u, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(prefix) != fmt.Sprint(tt.want) {
+				t.Errorf("prefix = %q, want %q", prefix, tt.want)
+			}
+		})
+	}
+}
+
+func TestS3Key(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+		// wantPath are the escaped paths of a request for the object
+		// with the bucket addressed in the path of the request, then in the hostname
+		wantPath []string
+	}{
+		{
+			url:      "s3://bucket/dir/file.txt",
+			want:     "dir/file.txt",
+			wantPath: []string{"/bucket/dir/file.txt", "/dir/file.txt"},
+		},
+		{
+			url:      "s3://bucket/dir%2Ffile.txt",
+			want:     "dir/file.txt",
+			wantPath: []string{"/bucket/dir/file.txt", "/dir/file.txt"},
+		},
+		{
+			url:      "s3://bucket/my%20file.txt",
+			want:     "my file.txt",
+			wantPath: []string{"/bucket/my%20file.txt", "/my%20file.txt"},
+		},
+		{
+			url:      "s3://bucket/my file.txt",
+			want:     "my file.txt",
+			wantPath: []string{"/bucket/my%20file.txt", "/my%20file.txt"},
+		},
+		{
+			url:      "s3://bucket/a+b.txt",
+			want:     "a+b.txt",
+			wantPath: []string{"/bucket/a%2Bb.txt", "/a%2Bb.txt"},
+		},
+		{
+			url:      "s3://bucket/a%2Bb.txt",
+			want:     "a+b.txt",
+			wantPath: []string{"/bucket/a%2Bb.txt", "/a%2Bb.txt"},
+		},
+		{
+			url:      "s3://bucket/logs/a%3Fb%23c.txt",
+			want:     "logs/a?b#c.txt",
+			wantPath: []string{"/bucket/logs/a%3Fb%23c.txt", "/logs/a%3Fb%23c.txt"},
+		},
+		{
+			url:      "s3://bucket/100%25.txt",
+			want:     "100%.txt",
+			wantPath: []string{"/bucket/100%25.txt", "/100%25.txt"},
+		},
+		{
+			url:      "s3://bucket//file.txt",
+			want:     "/file.txt",
+			wantPath: []string{"/bucket//file.txt", "//file.txt"},
+		},
+		{
+			url:      "s3://bucket/%2Ffile.txt",
+			want:     "/file.txt",
+			wantPath: []string{"/bucket//file.txt", "//file.txt"},
+		},
+		{
+			url:      "s3://bucket/file.txt?preserve_leading_slash",
+			want:     "/file.txt",
+			wantPath: []string{"/bucket//file.txt", "//file.txt"},
+		},
+		{
+			url:      "s3://bucket/a//b.txt",
+			want:     "a//b.txt",
+			wantPath: []string{"/bucket/a//b.txt", "/a//b.txt"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := s3Key(u); got != tt.want {
+				t.Errorf("s3Key(%q) = %q, want %q", tt.url, got, tt.want)
+			}
+
+			for i, pathStyle := range []bool{true, false} {
+				p := &S3Provider{
+					Endpoint:    "https://s3.example.com",
+					Region:      "us-east-1",
+					Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+					PathStyle:   pathStyle,
+				}
+
+				s, err := p.Session(u)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				req, _ := s3.New(s).GetObjectRequest(&s3.GetObjectInput{
+					Bucket: aws.String(u.Host),
+					Key:    aws.String(s3Key(u)),
+				})
+				err = req.Build()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				wantHost := "s3.example.com"
+				if !pathStyle {
+					wantHost = "bucket.s3.example.com"
+				}
+				if got := req.HTTPRequest.URL.EscapedPath(); got != tt.wantPath[i] || req.HTTPRequest.URL.Host != wantHost {
+					t.Errorf("path style %v: request %s%s, want %s%s", pathStyle, req.HTTPRequest.URL.Host, got, wantHost, tt.wantPath[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package fifo
+
+import (
+	"crypto/rand"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strconv"
+)
+
+// sftpConn is an SFTP session over an SSH connection.
+// Closing the session also closes the underlying connections.
+type sftpConn struct {
+	*sftp.Client
+	ssh   *ssh.Client
+	agent net.Conn
+}
+
+func (c *sftpConn) Close() error {
+	mu := Catch(nil, c.Client.Close(), c.ssh.Close())
+	if c.agent != nil {
+		mu = Catch(mu, c.agent.Close())
+	}
+	return mu.AsError()
+}
+
+// SFTPFile is an open remote file. Closing the file also closes its SFTP session.
+type SFTPFile struct {
+	*sftp.File
+	c *sftpConn
+}
+
+func (f *SFTPFile) Close() error {
+	return Catch(nil, f.File.Close(), f.c.Close()).AsError()
+}
+
+// SFTPPutFile is a remote file created on an SFTP server.
+// The file is written to a temporary file in the same directory, which is renamed to the destination when committed.
+type SFTPPutFile struct {
+	*sftp.File
+	c *sftpConn
+	p SFTPProvider
+	u *url.URL
+
+	committed bool
+	// closed is set once the SFTP session has been closed
+	closed bool
+}
+
+// Close closes the temporary file, keeping the SFTP session open to commit or destroy it.
+func (f *SFTPPutFile) Close() error {
+	return f.File.Close()
+}
+
+// Commit renames the temporary file to the destination and closes the SFTP session.
+// If the file cannot be renamed the temporary file is removed before the session is closed.
+func (f *SFTPPutFile) Commit() error {
+	err := f.c.PosixRename(f.Name(), f.u.Path)
+	if err != nil && f.c.Rename(f.Name(), f.u.Path) == nil {
+		// The server does not support replacing the destination, but it does not exist yet
+		err = nil
+	}
+	if err != nil {
+		f.closed = true
+		return Catch(nil, err, f.c.Remove(f.Name()), f.c.Close()).AsError()
+	}
+
+	f.committed = true
+	f.closed = true
+	return f.c.Close()
+}
+
+// Destroy removes the temporary file if it has not been committed, otherwise the destination is removed.
+func (f *SFTPPutFile) Destroy() error {
+	if !f.committed {
+		if f.closed {
+			// The temporary file was already removed by a failed commit
+			return nil
+		}
+		return Catch(nil, f.c.Remove(f.Name()), f.c.Close()).AsError()
+	}
+
+	c, err := f.p.Dial(f.u)
+	if err != nil {
+		return err
+	}
+
+	return Catch(nil, c.Remove(f.u.Path), c.Close()).AsError()
+}
+
+// SFTPProvider provides files from a remote SSH server using the SFTP subsystem.
+type SFTPProvider struct {
+	// KnownHosts is the known_hosts file used to verify the host key of a server
+	// unless `?known_hosts` is given. Defaults to ~/.ssh/known_hosts
+	KnownHosts string
+}
+
+func (SFTPProvider) Schema() []string {
+	return []string{"sftp"}
+}
+
+func (p SFTPProvider) auth(u *url.URL) (methods []ssh.AuthMethod, ag net.Conn, err error) {
+	q := u.Query()
+
+	if pw, ok := u.User.Password(); ok {
+		methods = append(methods, ssh.Password(pw))
+	}
+
+	if key := q.Get("key"); key != "" {
+		b, err := ioutil.ReadFile(key)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "unable to read private key %q", key)
+		}
+		signer, err := ssh.ParsePrivateKey(b)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "invalid private key %q", key)
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+
+	if queryFlag(q, "agent") {
+		sock := os.Getenv("SSH_AUTH_SOCK")
+		if sock == "" {
+			return nil, nil, errors.New("agent authentication requested but SSH_AUTH_SOCK is not set")
+		}
+		ag, err = net.Dial("unix", sock)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "unable to connect to SSH agent")
+		}
+		methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(ag).Signers))
+	}
+
+	if len(methods) == 0 {
+		return nil, nil, errors.New("no SFTP authentication method given, use a password, `?key` or `?agent`")
+	}
+
+	return
+}
+
+func (p SFTPProvider) config(u *url.URL) (*ssh.ClientConfig, net.Conn, error) {
+	name := u.User.Username()
+	if name == "" {
+		current, err := user.Current()
+		if err != nil {
+			return nil, nil, err
+		}
+		name = current.Username
+	}
+
+	known := u.Query().Get("known_hosts")
+	if known == "" {
+		known = p.KnownHosts
+	}
+	if known == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "unable to find known hosts, use `?known_hosts`")
+		}
+		known = filepath.Join(home, ".ssh", "known_hosts")
+	}
+
+	hostKeyCallback, err := knownhosts.New(known)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "unable to load known hosts from %q", known)
+	}
+
+	methods, ag, err := p.auth(u)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &ssh.ClientConfig{
+		User:            name,
+		Auth:            methods,
+		HostKeyCallback: hostKeyCallback,
+	}, ag, nil
+}
+
+// Dial opens a new SFTP session to the server of the given URL.
+func (p SFTPProvider) Dial(u *url.URL) (*sftpConn, error) {
+	config, ag, err := p.config(u)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+
+	conn, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		if ag != nil {
+			_ = ag.Close()
+		}
+		return nil, err
+	}
+
+	c, err := sftp.NewClient(conn)
+	if err != nil {
+		return nil, Catch(nil, err, conn.Close()).AsError()
+	}
+
+	return &sftpConn{
+		Client: c,
+		ssh:    conn,
+		agent:  ag,
+	}, nil
+}
+
+func (p SFTPProvider) Read(u *url.URL) (io.ReadCloser, error) {
+	c, err := p.Dial(u)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := c.Open(u.Path)
+	if err != nil {
+		return nil, Catch(nil, err, c.Close()).AsError()
+	}
+
+	return &SFTPFile{
+		File: f,
+		c:    c,
+	}, nil
+}
+
+func (p SFTPProvider) Write(u *url.URL) (WriteDestroyCloser, error) {
+	var mode os.FileMode
+	if chmod := u.Query().Get("chmod"); chmod != "" {
+		m, err := strconv.ParseUint(chmod, 8, 32)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid file mode option %q", chmod)
+		}
+		mode = os.FileMode(m)
+	}
+
+	c, err := p.Dial(u)
+	if err != nil {
+		return nil, err
+	}
+
+	suffix := make([]byte, 6)
+	_, err = rand.Read(suffix)
+	if err != nil {
+		return nil, Catch(nil, err, c.Close()).AsError()
+	}
+
+	dir, base := path.Split(u.Path)
+	tmp := fmt.Sprintf("%s.%s.%x.tmp", dir, base, suffix)
+
+	f, err := c.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return nil, Catch(nil, err, c.Close()).AsError()
+	}
+
+	if mode != 0 {
+		err = f.Chmod(mode)
+		if err != nil {
+			return nil, Catch(nil, err, f.Close(), c.Remove(tmp), c.Close()).AsError()
+		}
+	}
+
+	return &SFTPPutFile{
+		File: f,
+		c:    c,
+		p:    p,
+		u:    u,
+	}, nil
+}
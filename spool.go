@@ -0,0 +1,197 @@
+package fifo
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+// spoolPartSize is the size of each part of a source downloaded in parallel.
+const spoolPartSize = 8 << 20
+
+// A RangeSourceProvider is a source provider which can read part of a source,
+// allowing a source to be downloaded in parallel.
+type RangeSourceProvider interface {
+	SourceProvider
+	// Stat returns the size and version of a source.
+	// A negative size indicates that the source cannot be read in parts.
+	Stat(u *url.URL) (size int64, version string, err error)
+	// ReadRange reads length bytes of a source starting at offset,
+	// failing if the source is no longer the given version.
+	ReadRange(u *url.URL, version string, offset, length int64) (io.ReadCloser, error)
+}
+
+func provideRangeSource(u *url.URL, providers ...Provider) (RangeSourceProvider, bool) {
+	for _, p := range providers {
+		rp, ok := p.(RangeSourceProvider)
+		if !ok {
+			continue
+		}
+		for _, s := range p.Schema() {
+			if s == u.Scheme {
+				return rp, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// offsetWriter writes to a file starting at an offset.
+type offsetWriter struct {
+	f      *os.File
+	offset int64
+}
+
+func (w *offsetWriter) Write(b []byte) (int, error) {
+	n, err := w.f.WriteAt(b, w.offset)
+	w.offset += int64(n)
+	return n, err
+}
+
+// spoolRanges downloads a source to a file in parts of spoolPartSize using parallel range requests.
+// Returns false if the source cannot be read in parts,
+// including when its size cannot be found, such as when a server does not allow HEAD requests.
+// The source is then read sequentially, which reports any error opening it.
+func spoolRanges(f *os.File, u *url.URL, p RangeSourceProvider, parallel int) (bool, error) {
+	size, version, err := p.Stat(u)
+	if err != nil || size < 0 {
+		return false, nil
+	}
+
+	offsets := make(chan int64)
+	g, ctx := errgroup.WithContext(context.Background())
+	g.Go(func() error {
+		defer close(offsets)
+		for offset := int64(0); offset < size; offset += spoolPartSize {
+			select {
+			case offsets <- offset:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		return nil
+	})
+
+	for i := 0; i < parallel; i++ {
+		g.Go(func() error {
+			for offset := range offsets {
+				length := int64(spoolPartSize)
+				if offset+length > size {
+					length = size - offset
+				}
+
+				r, err := p.ReadRange(u, version, offset, length)
+				if err != nil {
+					return err
+				}
+
+				n, err := io.Copy(&offsetWriter{f: f, offset: offset}, io.LimitReader(r, length))
+				err = Catch(nil, err, r.Close()).AsError()
+				if err == nil && n != length {
+					err = errors.Errorf("expected %d bytes at offset %d but got %d", length, offset, n)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	return true, g.Wait()
+}
+
+// Spool downloads a source to a regular file, which can be read by the command in any order.
+// If the `?parallel` option is greater than 1 and the source supports range requests,
+// the source is downloaded in parts using that many parallel requests.
+// Parts are only used when the source has no stream options such as `?decompress`, `?decrypt` or a checksum,
+// otherwise the source is copied sequentially.
+func (t *Task) Spool(u *url.URL, f *os.File) error {
+	q := u.Query()
+
+	parallel := 1
+	if v := q.Get("parallel"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return errors.Errorf("invalid parallel option %q", v)
+		}
+		parallel = n
+	}
+
+	// The raw content of the source can be downloaded in parts if there are no other stream options to apply,
+	// in which case removing the stream options leaves the URL as-is.
+	raw := withoutQuery(u, "spool", "parallel")
+	if stream := withoutQuery(raw, streamOptions...); parallel > 1 && stream == raw && q.Get("range") == "" {
+		if p, ok := provideRangeSource(stream, t.Providers...); ok {
+			spooled, err := spoolRanges(f, stream, p, parallel)
+			if spooled || err != nil {
+				return err
+			}
+		}
+	}
+
+	s, err := t.OpenSource(raw)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, s)
+	return Catch(nil, err, s.Close()).AsError()
+}
+
+// SpooledTarget is a target written by the command to a regular file,
+// which is copied to the target stream when committed.
+type SpooledTarget struct {
+	// Path is the file written by the command
+	Path   string
+	Target WriteDestroyCloser
+
+	closed bool
+}
+
+func (s *SpooledTarget) Write(b []byte) (int, error) {
+	return s.Target.Write(b)
+}
+
+// Close does nothing, as the file is only copied to the target stream when committed.
+func (s *SpooledTarget) Close() error {
+	return nil
+}
+
+// Commit copies the file to the target stream, then closes and commits the target stream.
+func (s *SpooledTarget) Commit() error {
+	f, err := os.Open(s.Path)
+	if os.IsNotExist(err) {
+		return errors.Errorf("the command did not create the spooled target %s", s.Path)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(s.Target, f)
+	err = Catch(nil, err, f.Close()).AsError()
+	if err != nil {
+		return err
+	}
+
+	s.closed = true
+	err = s.Target.Close()
+	if err != nil {
+		return err
+	}
+
+	return s.Target.Commit()
+}
+
+// Destroy destroys the target stream.
+func (s *SpooledTarget) Destroy() error {
+	if !s.closed {
+		s.closed = true
+		_ = s.Target.Close()
+	}
+	return s.Target.Destroy()
+}
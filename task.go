@@ -1,11 +1,13 @@
 package fifo
 
 import (
+	"github.com/pkg/errors"
 	"io"
 	"net/url"
 	"os"
 	"path/filepath"
 	"syscall"
+	"time"
 )
 
 type Call struct {
@@ -15,12 +17,41 @@ type Call struct {
 	WorkingDirectory string
 }
 
+const (
+	// ModeFifo passes sources and targets to the command as named pipes created in the mount directory.
+	ModeFifo = "fifo"
+	// ModeFd passes sources and targets to the command as inherited file descriptors named by /dev/fd/N.
+	ModeFd = "fd"
+)
+
+// ValidMode returns an error if the named mode of passing pipes to the command is not supported.
+// An empty mode is the same as ModeFifo.
+func ValidMode(mode string) error {
+	switch mode {
+	case "", ModeFifo, ModeFd:
+		return nil
+	}
+	return errors.Errorf("unsupported pipe mode %q", mode)
+}
+
 type Task struct {
 	Call Call
 	// Preserve created target objects on failure
-	Preserve       bool
+	Preserve bool
+	// SuccessCodes are the exit codes of the command considered successful. Defaults to 0
+	SuccessCodes   ExitCodes
 	MountDirectory string
-	Providers      []Provider
+	// PipeMode is the permissions of pipes and spooled sources created in the mount directory. Defaults to 0600
+	PipeMode os.FileMode
+	// PipeNames names pipes in the mount directory. Defaults to RandomPipeNames
+	PipeNames PipeNamer
+	// Mode is how sources and targets are passed to the command, either ModeFifo or ModeFd. Defaults to ModeFifo
+	Mode string
+	// OpenTimeout is how long to wait for the command to open each pipe. Zero waits until the command exits
+	OpenTimeout time.Duration
+	Providers   []Provider
+	// Keys are the default keys used to encrypt and decrypt streams
+	Keys Keys
 
 	// Sources provides a mapping of directory local named pipes to their equivalent URL
 	Sources UrlMapping
@@ -31,21 +62,238 @@ type Task struct {
 	// Stdout is the target URL (if defined) for the output of the command
 	Stdout *Url
 	Stderr *Url
+
+	// reserved are the paths of pipes already created in the mount directory
+	reserved map[string]bool
+}
+
+// streamOptions are query options applied by the task to the stream of any provider.
+var streamOptions = []string{
+	"compress", "decompress",
+	"encrypt", "recipient", "recipients_file",
+	"decrypt", "identity", "passphrase_file",
+	"md5", "sha1", "sha256", "sha512", "verify",
+	"spool", "parallel",
+}
+
+// OpenSource opens the stream of a source URL using the task providers.
+// The digests of the stream are verified against any expected digests given by `?sha256` and similar options,
+// then the stream is decrypted if `?decrypt` is enabled and decompressed if `?decompress` is given.
+func (t *Task) OpenSource(u *url.URL) (io.ReadCloser, error) {
+	q := u.Query()
+	format := q.Get("decompress")
+	err := ValidCompression(format, true)
+	if err != nil {
+		return nil, err
+	}
+
+	expected, err := ParseDigests(q)
+	if err != nil {
+		return nil, err
+	}
+
+	identities, err := t.Keys.SourceIdentities(q)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := ProvideSource(withoutQuery(u, streamOptions...), t.Providers...)
+	if err != nil {
+		return nil, err
+	}
+
+	s = VerifySource(s, expected)
+
+	d, err := Decrypt(s, identities...)
+	if err != nil {
+		return nil, Catch(nil, err, s.Close()).AsError()
+	}
+
+	c, err := Decompress(d, format)
+	if err != nil {
+		return nil, Catch(nil, err, d.Close()).AsError()
+	}
+
+	return c, nil
 }
 
-func (t *Task) Source(u *url.URL) (*SourcePipe, error) {
-	rel := filepath.Join(t.MountDirectory, urlToFilename(u))
+// OpenTarget opens the stream of a target URL using the task providers.
+// The stream is compressed if `?compress` is given and then encrypted if `?encrypt` is enabled.
+// The digests of the encoded stream are compared against any expected digests given by `?sha256` and similar options,
+// and verified by the provider if `?verify` is enabled.
+func (t *Task) OpenTarget(u *url.URL) (WriteDestroyCloser, error) {
+	q := u.Query()
+	format := q.Get("compress")
+	err := ValidCompression(format, false)
+	if err != nil {
+		return nil, err
+	}
+
+	expected, err := ParseDigests(q)
+	if err != nil {
+		return nil, err
+	}
 
-	s, err := ProvideSource(u, t.Providers...)
+	recipients, err := t.Keys.TargetRecipients(q)
 	if err != nil {
 		return nil, err
 	}
 
-	err = syscall.Mkfifo(rel, 0666)
+	s, err := ProvideTarget(withoutQuery(u, streamOptions...), t.Providers...)
 	if err != nil {
 		return nil, err
 	}
 
+	verify := queryFlag(q, "verify")
+	if _, ok := s.(Checksummer); verify && !ok {
+		return nil, Catch(nil, errors.Errorf("the %s target provider does not support checksum verification", u.Scheme), s.Close(), s.Destroy()).AsError()
+	}
+
+	s = VerifyTarget(s, expected, verify)
+
+	e, err := Encrypt(s, recipients...)
+	if err != nil {
+		return nil, Catch(nil, err, s.Close(), s.Destroy()).AsError()
+	}
+
+	c, err := Compress(e, format)
+	if err != nil {
+		return nil, Catch(nil, err, e.Close(), e.Destroy()).AsError()
+	}
+
+	return c, nil
+}
+
+// UsesMountDirectory returns true if any source or target is created in the mount directory,
+// either as a named pipe or as a spooled file.
+func (t *Task) UsesMountDirectory() bool {
+	for _, m := range []UrlMapping{t.Sources, t.Targets} {
+		for _, u := range m {
+			if t.Mode != ModeFd || queryFlag((*url.URL)(u).Query(), "spool") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pipeMode returns the permissions of pipes and spooled sources. Defaults to 0600
+func (t *Task) pipeMode() os.FileMode {
+	if t.PipeMode == 0 {
+		return 0600
+	}
+	return t.PipeMode
+}
+
+// pipeName returns the name of the pipe for a tag.
+// Names after the first are numbered candidates for when the first name is already used.
+func (t *Task) pipeName(tag string, u *url.URL, n int) string {
+	namer := t.PipeNames
+	if namer == nil {
+		namer = RandomPipeNames{}
+	}
+
+	name := namer.PipeName(tag, u)
+	if n > 1 {
+		name = numberFilename(name, n)
+	}
+	return name
+}
+
+// createPipe creates the pipe for a tag in the mount directory, returning its path.
+// create is called with each candidate path of the pipe until it does not fail because the path already exists.
+func (t *Task) createPipe(tag string, u *url.URL, create func(path string) error) (string, error) {
+	for n := 1; ; n++ {
+		path := filepath.Join(t.MountDirectory, t.pipeName(tag, u, n))
+		if t.reserved[path] {
+			continue
+		}
+
+		err := create(path)
+		if os.IsExist(err) && n < 100 {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if t.reserved == nil {
+			t.reserved = make(map[string]bool)
+		}
+		t.reserved[path] = true
+		return path, nil
+	}
+}
+
+// mkfifo creates a named pipe with the task's pipe permissions, regardless of umask.
+func (t *Task) mkfifo(path string) error {
+	err := syscall.Mkfifo(path, uint32(t.pipeMode()))
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(path, t.pipeMode())
+	if err != nil {
+		return Catch(nil, err, os.Remove(path)).AsError()
+	}
+	return nil
+}
+
+func (t *Task) Source(tag string, u *url.URL) (*SourcePipe, error) {
+	if err := ValidMode(t.Mode); err != nil {
+		return nil, err
+	}
+
+	if queryFlag(u.Query(), "spool") {
+		var f *os.File
+		rel, err := t.createPipe(tag, u, func(path string) (err error) {
+			f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, t.pipeMode())
+			return
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		err = f.Chmod(t.pipeMode())
+		if err == nil {
+			err = t.Spool(u, f)
+		}
+		err = Catch(nil, err, f.Close()).AsError()
+		if err != nil {
+			return nil, Catch(nil, err, os.Remove(rel)).AsError()
+		}
+
+		return &SourcePipe{
+			Path:    rel,
+			URL:     u,
+			Spooled: true,
+		}, nil
+	}
+
+	s, err := t.OpenSource(u)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Mode == ModeFd {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, Catch(nil, err, s.Close()).AsError()
+		}
+
+		return &SourcePipe{
+			URL:    u,
+			Stream: s,
+			Pipe:   w,
+			Child:  r,
+		}, nil
+	}
+
+	rel, err := t.createPipe(tag, u, t.mkfifo)
+	if err != nil {
+		return nil, Catch(nil, err, s.Close()).AsError()
+	}
+
 	return &SourcePipe{
 		Path:   rel,
 		URL:    u,
@@ -53,17 +301,60 @@ func (t *Task) Source(u *url.URL) (*SourcePipe, error) {
 	}, nil
 }
 
-func (t *Task) Target(u *url.URL) (*TargetPipe, error) {
-	rel := filepath.Join(t.MountDirectory, urlToFilename(u))
+func (t *Task) Target(tag string, u *url.URL) (*TargetPipe, error) {
+	if err := ValidMode(t.Mode); err != nil {
+		return nil, err
+	}
 
-	s, err := ProvideTarget(u, t.Providers...)
+	s, err := t.OpenTarget(u)
 	if err != nil {
 		return nil, err
 	}
 
-	err = syscall.Mkfifo(rel, 0666)
+	if queryFlag(u.Query(), "spool") {
+		// The file is created by the command, so only check that the path is not already used
+		rel, err := t.createPipe(tag, u, func(path string) error {
+			_, err := os.Lstat(path)
+			if err == nil {
+				return os.ErrExist
+			}
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			return nil, Catch(nil, err, s.Close(), s.Destroy()).AsError()
+		}
+
+		return &TargetPipe{
+			Path: rel,
+			URL:  u,
+			Stream: &SpooledTarget{
+				Path:   rel,
+				Target: s,
+			},
+			Spooled: true,
+		}, nil
+	}
+
+	if t.Mode == ModeFd {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, Catch(nil, err, s.Close(), s.Destroy()).AsError()
+		}
+
+		return &TargetPipe{
+			URL:    u,
+			Stream: s,
+			Pipe:   r,
+			Child:  w,
+		}, nil
+	}
+
+	rel, err := t.createPipe(tag, u, t.mkfifo)
 	if err != nil {
-		return nil, err
+		return nil, Catch(nil, err, s.Close(), s.Destroy()).AsError()
 	}
 
 	return &TargetPipe{
@@ -77,7 +368,7 @@ func (t *Task) SetupInput() (io.ReadCloser, error) {
 	if t.Stdin == nil {
 		return os.Stdin, nil
 	}
-	return ProvideSource((*url.URL)(t.Stdin), t.Providers...)
+	return t.OpenSource((*url.URL)(t.Stdin))
 }
 
 type NoOpWriteDestroyCloser struct {
@@ -88,13 +379,17 @@ func (NoOpWriteDestroyCloser) Close() error {
 	return nil
 }
 
+func (NoOpWriteDestroyCloser) Commit() error {
+	return nil
+}
+
 func (NoOpWriteDestroyCloser) Destroy() error {
 	return nil
 }
 
 func (t *Task) SetupOutput() (stdout WriteDestroyCloser, stderr WriteDestroyCloser, err error) {
 	if t.Stdout != nil {
-		stdout, err = ProvideTarget((*url.URL)(t.Stdout), t.Providers...)
+		stdout, err = t.OpenTarget((*url.URL)(t.Stdout))
 		if err != nil {
 			return
 		}
@@ -103,9 +398,11 @@ func (t *Task) SetupOutput() (stdout WriteDestroyCloser, stderr WriteDestroyClos
 	}
 
 	if t.Stderr != nil {
-		stderr, err = ProvideTarget((*url.URL)(t.Stderr), t.Providers...)
+		stderr, err = t.OpenTarget((*url.URL)(t.Stderr))
 		if err != nil {
-			return
+			// The command is not started so stdout is destroyed
+			err = Catch(nil, err, stdout.Close(), stdout.Destroy()).AsError()
+			return nil, nil, err
 		}
 	} else {
 		stderr = &NoOpWriteDestroyCloser{Writer: os.Stderr}
@@ -7,13 +7,14 @@ import (
 	"github.com/valyala/fasttemplate"
 	"io"
 	"net/url"
+	"os"
 	"strings"
 )
 
-// A PipeProvider is given a URL and should return a Source or Target pipe.
+// A PipeProvider is given a tag and its URL and should return a Source or Target pipe.
 type PipeProvider interface {
-	Source(u *url.URL) (*SourcePipe, error)
-	Target(u *url.URL) (*TargetPipe, error)
+	Source(tag string, u *url.URL) (*SourcePipe, error)
+	Target(tag string, u *url.URL) (*TargetPipe, error)
 }
 
 // A TemplateGenerator replaces command-line argument values with a real location of a fifo on the file-system
@@ -25,6 +26,51 @@ type TemplateGenerator struct {
 
 	TargetTags UrlMapping
 	Targets    Targets
+
+	// Files are the ends of anonymous pipes to be inherited by the command, in file descriptor order from 3.
+	Files []*os.File
+}
+
+// inherit adds the end of an anonymous pipe to the files inherited by the command,
+// returning the path of its file descriptor as seen by the command.
+func (g *TemplateGenerator) inherit(f *os.File) string {
+	g.Files = append(g.Files, f)
+	return fmt.Sprintf("/dev/fd/%d", 2+len(g.Files))
+}
+
+// CloseFiles closes the ends of anonymous pipes inherited by the command.
+// This must be done once the command has started so that the end of each stream is seen by the command and by fifo.
+func (g *TemplateGenerator) CloseFiles() (mu *MultiError) {
+	for _, f := range g.Files {
+		mu = Catch(mu, f.Close())
+	}
+	return
+}
+
+// Abandon releases the pipes and streams created for a command which will not be started,
+// closing sources and destroying targets.
+func (g *TemplateGenerator) Abandon() *MultiError {
+	mu := new(MultiError)
+	mu.CatchMulti(g.CloseFiles)
+
+	for _, src := range g.Sources {
+		if src.Pipe != nil {
+			mu.Append(src.Pipe.Close())
+		}
+		if src.Stream != nil {
+			mu.Append(src.Stream.Close())
+		}
+	}
+
+	for _, tg := range g.Targets {
+		if tg.Pipe != nil {
+			mu.Append(tg.Pipe.Close())
+		}
+		mu.Catch(tg.Stream.Close, tg.Stream.Destroy)
+	}
+
+	mu.CatchMulti(g.Sources.Teardown, g.Targets.Teardown)
+	return mu
 }
 
 func (g *TemplateGenerator) provide(w io.Writer, tag string) (int, error) {
@@ -39,18 +85,26 @@ func (g *TemplateGenerator) provide(w io.Writer, tag string) (int, error) {
 	}
 
 	if sok {
-		p, err := g.Provider.Source((*url.URL)(st))
+		p, err := g.Provider.Source(tag, (*url.URL)(st))
 		if err != nil {
 			return 0, err
 		}
+		if p.Child != nil {
+			p.Path = g.inherit(p.Child)
+		}
+		p.Name = tag
 		g.Sources = append(g.Sources, p)
 		return fmt.Fprint(w, p.Path)
 
 	} else {
-		p, err := g.Provider.Target((*url.URL)(tt))
+		p, err := g.Provider.Target(tag, (*url.URL)(tt))
 		if err != nil {
 			return 0, err
 		}
+		if p.Child != nil {
+			p.Path = g.inherit(p.Child)
+		}
+		p.Name = tag
 		g.Targets = append(g.Targets, p)
 		return fmt.Fprint(w, p.Path)
 	}